@@ -0,0 +1,319 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/utils"
+)
+
+var (
+	ErrUnexpectedResponse = fmt.Errorf("Unexpected SIP response")
+	ErrSeqNumMismatch     = fmt.Errorf("SIP response sequence number does not match request")
+	ErrNoResponse         = fmt.Errorf("No SIP response received")
+	ErrLoginRefused       = fmt.Errorf("SC Login refused by ACS")
+)
+
+type Config struct {
+	TerminatorCharacter rune
+	DelimiterCharacter  rune
+	Timeout             int
+	ErrorDetection      bool
+	Retries             int
+
+	// TLSConfig enables TLS when set. Dial fills in ServerName from the address if it is empty.
+	TLSConfig *tls.Config
+}
+
+func DefaultConfig() Config {
+	return Config{
+		TerminatorCharacter: '\r',
+		DelimiterCharacter:  '|',
+		Timeout:             5,
+		ErrorDetection:      true,
+		Retries:             3,
+		TLSConfig:           nil,
+	}
+}
+
+// Client runs a SIP conversation with an ACS over a single connection. Requests are sent one at a time and each call blocks until the matching response has been read.
+type Client struct {
+	mu sync.Mutex
+
+	conn    net.Conn
+	scanner *bufio.Scanner
+	seqNum  int
+
+	terminatorCharacter rune
+	delimiterCharacter  rune
+	timeout             int
+	errorDetection      bool
+	retries             int
+}
+
+// Dial connects to the ACS listening on address ("host:port") and returns a Client using that connection.
+func Dial(address string, cfg Config) (*Client, error) {
+	err := validateConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: time.Second * time.Duration(cfg.Timeout)}
+
+	var conn net.Conn
+	if cfg.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, cfg.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return New(conn, cfg)
+}
+
+// New returns a Client that talks to the ACS over an already established connection.
+func New(conn net.Conn, cfg Config) (*Client, error) {
+	err := validateConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ConfigureCharacters(cfg.DelimiterCharacter, cfg.TerminatorCharacter)
+
+	scanner := bufio.NewScanner(bufio.NewReader(conn))
+	scanner.Split(utils.GenerateLineScanner(cfg.TerminatorCharacter))
+
+	return &Client{
+		conn:    conn,
+		scanner: scanner,
+		seqNum:  0,
+
+		terminatorCharacter: cfg.TerminatorCharacter,
+		delimiterCharacter:  cfg.DelimiterCharacter,
+		timeout:             cfg.Timeout,
+		errorDetection:      cfg.ErrorDetection,
+		retries:             cfg.Retries,
+	}, nil
+}
+
+// ConfigureCharacters sets up the validators of the request and response packages and the escape characters of utils for delimiter and terminator. New calls it for every Client; it does nothing if they are already set up for the same characters, so it is safe to call while other goroutines are using them.
+func ConfigureCharacters(delimiter, terminator rune) {
+	utils.ConfigureEscapeCharacters(delimiter, terminator)
+	request.InitValidator(delimiter, terminator)
+	response.InitValidator(delimiter, terminator)
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Timeout < 1 {
+		return fmt.Errorf("invalid timeout - must be greater than zero seconds.")
+	}
+
+	if cfg.TerminatorCharacter == cfg.DelimiterCharacter {
+		return fmt.Errorf("cannot use the same character for both Terminator and Delimiter")
+	}
+
+	if cfg.Retries < 0 {
+		return fmt.Errorf("invalid retries - must not be negative")
+	}
+
+	return nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Send writes req to the ACS and reads one response back. When error detection is enabled the request is stamped with the next sequence number and the response must echo it. A response with a bad checksum is requested again with an ACS Resend, and an SC Resend from the ACS retransmits the request, each up to Retries times.
+func (c *Client) Send(req request.Request) (response.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seqNum := c.seqNum
+	if c.errorDetection {
+		req.SetSeqNum(seqNum)
+		c.seqNum = utils.IncrementSeqNum(c.seqNum)
+	}
+
+	reqString := req.Marshal(c.delimiterCharacter, c.terminatorCharacter, c.errorDetection)
+	msg := reqString
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.roundTripLine(msg)
+		if nse, ok := err.(*notSentError); ok && attempt > 0 {
+			// The ACS has already received the request in an earlier attempt.
+			err = nse.err
+		}
+		if c.errorDetection && attempt < c.retries {
+			if errors.Is(err, utils.ErrChecksumMismatch) || errors.Is(err, utils.ErrInvalidSeqNum) {
+				msg = (&request.ACSResend{}).Marshal(c.delimiterCharacter, c.terminatorCharacter, c.errorDetection)
+				continue
+			}
+			if _, resend := resp.(*response.SCResend); resend {
+				msg = reqString
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if c.errorDetection {
+			if _, resend := req.(*request.ACSResend); !resend {
+				if _, resend := resp.(*response.SCResend); !resend && resp.GetSeqNum() != seqNum {
+					return resp, ErrSeqNumMismatch
+				}
+			}
+		}
+
+		return resp, nil
+	}
+}
+
+// roundTripLine writes msg and reads and parses the next line from the ACS.
+func (c *Client) roundTripLine(msg string) (response.Response, error) {
+	deadline := time.Now().Add(time.Second * time.Duration(c.timeout))
+	c.conn.SetDeadline(deadline)
+
+	n, err := c.conn.Write([]byte(msg))
+	if err != nil {
+		if n == 0 {
+			return nil, &notSentError{err: err}
+		}
+		return nil, err
+	}
+
+	if !c.scanner.Scan() {
+		err = c.scanner.Err()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNoResponse
+	}
+
+	line := c.scanner.Text()
+	if c.errorDetection {
+		resp, _, _, err := response.UnmarshalVerified(line, c.delimiterCharacter, c.terminatorCharacter)
+		return resp, err
+	}
+
+	resp, _, err := response.Unmarshal(line, c.delimiterCharacter, c.terminatorCharacter)
+	return resp, err
+}
+
+// notSentError is a failure to write a request of which nothing reached the ACS, so that it is safe to send it again over another connection.
+type notSentError struct {
+	err error
+}
+
+func (e *notSentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notSentError) Unwrap() error {
+	return e.err
+}
+
+// closedByPeer reports whether the ACS has closed the idle connection, or sent something on it unasked, without waiting for it to do so.
+func (c *Client) closedByPeer() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A deadline already in the past would fail the read without looking at the connection.
+	c.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	var buf [1]byte
+	_, err := c.conn.Read(buf[:])
+	var netErr net.Error
+	return !errors.As(err, &netErr) || !netErr.Timeout()
+}
+
+func roundTrip[T response.Response](c *Client, req request.Request) (T, error) {
+	var zero T
+
+	resp, err := c.Send(req)
+	if err != nil {
+		return zero, err
+	}
+
+	typed, ok := resp.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: %T", ErrUnexpectedResponse, resp)
+	}
+
+	return typed, nil
+}
+
+func (c *Client) Login(r *request.SCLogin) (*response.SCLogin, error) {
+	return roundTrip[*response.SCLogin](c, r)
+}
+
+func (c *Client) Status(r *request.SCStatus) (*response.ACSStatus, error) {
+	return roundTrip[*response.ACSStatus](c, r)
+}
+
+// Resend asks the ACS to retransmit its last message, which may be of any type.
+func (c *Client) Resend() (response.Response, error) {
+	return c.Send(&request.ACSResend{})
+}
+
+func (c *Client) BlockPatron(r *request.BlockPatron) (*response.PatronStatus, error) {
+	return roundTrip[*response.PatronStatus](c, r)
+}
+
+func (c *Client) Checkin(r *request.Checkin) (*response.Checkin, error) {
+	return roundTrip[*response.Checkin](c, r)
+}
+
+func (c *Client) Checkout(r *request.Checkout) (*response.Checkout, error) {
+	return roundTrip[*response.Checkout](c, r)
+}
+
+func (c *Client) Hold(r *request.Hold) (*response.Hold, error) {
+	return roundTrip[*response.Hold](c, r)
+}
+
+func (c *Client) ItemInfo(r *request.ItemInfo) (*response.ItemInfo, error) {
+	return roundTrip[*response.ItemInfo](c, r)
+}
+
+func (c *Client) ItemStatusUpdate(r *request.ItemStatusUpdate) (*response.ItemStatusUpdate, error) {
+	return roundTrip[*response.ItemStatusUpdate](c, r)
+}
+
+func (c *Client) PatronStatus(r *request.PatronStatus) (*response.PatronStatus, error) {
+	return roundTrip[*response.PatronStatus](c, r)
+}
+
+func (c *Client) PatronEnable(r *request.PatronEnable) (*response.PatronEnable, error) {
+	return roundTrip[*response.PatronEnable](c, r)
+}
+
+func (c *Client) Renew(r *request.Renew) (*response.Renew, error) {
+	return roundTrip[*response.Renew](c, r)
+}
+
+func (c *Client) EndPatronSession(r *request.EndPatronSession) (*response.EndSession, error) {
+	return roundTrip[*response.EndSession](c, r)
+}
+
+func (c *Client) FeePaid(r *request.FeePaid) (*response.FeePaid, error) {
+	return roundTrip[*response.FeePaid](c, r)
+}
+
+func (c *Client) PatronInfo(r *request.PatronInfo) (*response.PatronInfo, error) {
+	return roundTrip[*response.PatronInfo](c, r)
+}
+
+func (c *Client) RenewAll(r *request.RenewAll) (*response.RenewAll, error) {
+	return roundTrip[*response.RenewAll](c, r)
+}
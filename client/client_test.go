@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/utils"
+)
+
+func fakeACS(t *testing.T, conn net.Conn, cfg Config) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(utils.GenerateLineScanner(cfg.TerminatorCharacter))
+
+	for scanner.Scan() {
+		req, _, err := request.Unmarshal(scanner.Text(), cfg.DelimiterCharacter, cfg.TerminatorCharacter)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var resp response.Response
+		switch r := req.(type) {
+		case *request.SCLogin:
+			resp = &response.SCLogin{Ok: r.LoginPassword == "secret"}
+		case *request.PatronInfo:
+			resp = &response.PatronInfo{
+				TransactionDate:     time.Now().UTC().Truncate(time.Second),
+				InstitutionID:       r.InstitutionID,
+				PatronID:            r.PatronID,
+				PatronName:          "Doe, John",
+				ValidPatron:         true,
+				ValidPatronPassword: r.PatronPassword == "pass",
+			}
+		default:
+			return
+		}
+
+		resp.SetSeqNum(req.GetSeqNum())
+		conn.Write([]byte(resp.Marshal(cfg.DelimiterCharacter, cfg.TerminatorCharacter, cfg.ErrorDetection)))
+	}
+}
+
+func TestClient(t *testing.T) {
+	cfg := DefaultConfig()
+
+	clientConn, acsConn := net.Pipe()
+	go fakeACS(t, acsConn, cfg)
+
+	c, err := New(clientConn, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	login, err := c.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !login.Ok {
+		t.Fatalf("login failed")
+	}
+
+	info, err := c.PatronInfo(&request.PatronInfo{
+		TransactionDate: time.Now(),
+		InstitutionID:   "inst",
+		PatronID:        "johndoe",
+		PatronPassword:  "pass",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.SeqNum != 1 {
+		t.Fatalf("Sequence Number mismatch")
+	}
+
+	if info.PatronID != "johndoe" || !info.ValidPatronPassword {
+		t.Fatalf("unexpected patron info response: %v", info)
+	}
+
+	_, err = c.Status(&request.SCStatus{ProtocolVersion: "2.00"})
+	if err == nil {
+		t.Fatalf("expected error for unanswered request")
+	}
+}
+
+// TestNewWhileValidating creates Clients while other goroutines validate requests with the same characters, as a proxy does when it dials the ACS while serving SCs. Run with -race.
+func TestNewWhileValidating(t *testing.T) {
+	cfg := DefaultConfig()
+	request.InitValidator(cfg.DelimiterCharacter, cfg.TerminatorCharacter)
+
+	line := (&request.ItemInfo{TransactionDate: time.Now(), InstitutionID: "inst", ItemID: "1234"}).Marshal(cfg.DelimiterCharacter, cfg.TerminatorCharacter, false)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, _, err := request.Unmarshal(line, cfg.DelimiterCharacter, cfg.TerminatorCharacter)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			clientConn, acsConn := net.Pipe()
+			defer acsConn.Close()
+
+			c, err := New(clientConn, cfg)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			c.Close()
+		}()
+	}
+	wg.Wait()
+}
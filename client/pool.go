@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+// Pool keeps connections to an ACS open for reuse by concurrent callers. At most size connections are open or being opened at a time; callers wait for one to be returned when all are in use.
+type Pool struct {
+	dial        func() (*Client, error)
+	idleTimeout time.Duration
+
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []idleClient
+	closed bool
+}
+
+type idleClient struct {
+	c     *Client
+	since time.Time
+}
+
+// NewPool returns a Pool of at most size connections opened with dial, for example DialLogin. A connection left unused for idleTimeout is closed rather than reused, so idleTimeout should be shorter than the ACS's own idle timeout.
+func NewPool(size int, idleTimeout time.Duration, dial func() (*Client, error)) (*Pool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid pool size - must be greater than zero")
+	}
+
+	return &Pool{
+		dial:        dial,
+		idleTimeout: idleTimeout,
+		slots:       make(chan struct{}, size),
+	}, nil
+}
+
+// Send sends req over a connection from the pool, waiting for one to become free until ctx is done. Idle connections the ACS has closed are replaced before they are used. If a reused connection still fails, the request is sent again over another one only when none of it was written, or when it is a request that does not change anything on the ACS; a Checkout that may have reached the ACS is never sent twice.
+func (p *Pool) Send(ctx context.Context, req request.Request) (response.Response, error) {
+	for {
+		c, reused, err := p.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.Send(req)
+		p.put(c, err != nil)
+		if err != nil && reused && retryable(req, err) {
+			continue
+		}
+		return resp, err
+	}
+}
+
+// get returns a connection to the ACS and whether it was reused from the pool rather than newly dialed. The connection must be given back with put.
+func (p *Pool) get(ctx context.Context) (*Client, bool, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+
+	if c := p.popIdle(); c != nil {
+		return c, true, nil
+	}
+
+	c, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, false, err
+	}
+	return c, false, nil
+}
+
+// popIdle returns the most recently used idle connection, closing those that have been idle longer than idleTimeout.
+func (p *Pool) popIdle() *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.idle) > 0 {
+		ic := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if time.Since(ic.since) < p.idleTimeout && !ic.c.closedByPeer() {
+			return ic.c
+		}
+		ic.c.Close()
+	}
+	return nil
+}
+
+// put returns c to the pool, or closes it if it can no longer be used.
+func (p *Pool) put(c *Client, broken bool) {
+	defer func() {
+		<-p.slots
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if broken || p.closed {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, idleClient{c: c, since: time.Now()})
+}
+
+// Close closes the idle connections. Connections in use are closed when they are returned.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, ic := range p.idle {
+		ic.c.Close()
+	}
+	p.idle = nil
+	return nil
+}
+
+// ReadOnly reports whether req changes nothing on the ACS: SC Status, Patron Status, Patron Info and Item Info requests. The Pool retries these after a lost connection, and the proxy shadows them by default.
+func ReadOnly(req request.Request) bool {
+	switch req.(type) {
+	case *request.SCStatus, *request.PatronStatus, *request.PatronInfo, *request.ItemInfo:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether req can be sent again after failing with err. A request that was not written at all can always be; otherwise the ACS may have acted on it before the connection was lost, so only requests that are ReadOnly are.
+func retryable(req request.Request, err error) bool {
+	var nse *notSentError
+	if errors.As(err, &nse) {
+		return true
+	}
+
+	if !errors.Is(err, ErrNoResponse) && !errors.Is(err, syscall.ECONNRESET) && !errors.Is(err, syscall.EPIPE) {
+		return false
+	}
+
+	return ReadOnly(req)
+}
+
+// DialLogin connects to the ACS like Dial and sends login as the first request. If the ACS refuses the login the connection is closed and the error is ErrLoginRefused.
+func DialLogin(address string, cfg Config, login *request.SCLogin) (*Client, error) {
+	c, err := Dial(address, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Login(login)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if !resp.Ok {
+		c.Close()
+		return nil, ErrLoginRefused
+	}
+	return c, nil
+}
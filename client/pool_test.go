@@ -0,0 +1,104 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/utils"
+)
+
+func TestPoolRetry(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ErrorDetection = false
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// The ACS answers SC Status requests except the third one, and never answers a Checkout. Either way it closes the connection after reading the request.
+	var mu sync.Mutex
+	received := map[string]int{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				scanner := bufio.NewScanner(conn)
+				scanner.Split(utils.GenerateLineScanner(cfg.TerminatorCharacter))
+				for scanner.Scan() {
+					code := scanner.Text()[0:2]
+
+					mu.Lock()
+					received[code]++
+					count := received[code]
+					mu.Unlock()
+
+					if code != "99" || count == 3 {
+						return
+					}
+
+					resp := &response.ACSStatus{OnlineStatus: true, DateTimeSync: time.Now(), ProtocolVersion: "2.00", InstitutionID: "inst"}
+					conn.Write([]byte(resp.Marshal(cfg.DelimiterCharacter, cfg.TerminatorCharacter, false)))
+				}
+			}()
+		}
+	}()
+
+	pool, err := NewPool(1, time.Minute, func() (*Client, error) {
+		return Dial(listener.Addr().String(), cfg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	_, err = NewPool(0, time.Minute, nil)
+	if err == nil {
+		t.Fatal("expected a pool of size zero to be refused")
+	}
+
+	ctx := context.Background()
+	status := &request.SCStatus{ProtocolVersion: "2.00"}
+
+	_, err = pool.Send(ctx, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The Checkout may have been applied by the ACS before it closed the connection, so it is not sent again.
+	_, err = pool.Send(ctx, &request.Checkout{TransactionDate: time.Now(), NBDueDate: time.Now(), InstitutionID: "inst", PatronID: "123", ItemID: "456"})
+	if !errors.Is(err, ErrNoResponse) {
+		t.Fatalf("expected no response to the Checkout, got %v", err)
+	}
+
+	_, err = pool.Send(ctx, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An SC Status changes nothing, so it is sent again over a new connection.
+	_, err = pool.Send(ctx, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received["11"] != 1 || received["99"] != 4 {
+		t.Fatalf("ACS received %v, expected one Checkout and four SC Status requests", received)
+	}
+}
@@ -0,0 +1,75 @@
+// Command sipd runs a SIP2 server configured from a config file and SIP_* environment variables, see server.LoadConfig. It answers SC Status requests and authenticates SC Login requests against the accounts_file.
+//
+// Sending sipd SIGHUP reloads the config file, including the accounts file, without dropping connections. SIGINT or SIGTERM shut it down gracefully.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pescew/sip/server"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to a JSON, YAML or TOML config file")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "time to wait for connections to finish their current message on shutdown")
+	flag.Parse()
+
+	cfg, err := server.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Error loading config: %s", err.Error())
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		log.Fatalf("Error creating server: %s", err.Error())
+	}
+	srv.HandleSCStatus(srv.DefaultSCStatusHandler)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.ListenAndServe()
+	}()
+
+	for {
+		select {
+		case err := <-served:
+			log.Fatalf("Server stopped: %s", err.Error())
+
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				cfg, err := server.LoadConfig(*configPath)
+				if err == nil {
+					err = srv.Reload(cfg)
+				}
+				if err != nil {
+					log.Printf("Error reloading config, keeping the current config: %s", err.Error())
+				}
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+			err := srv.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Error shutting down, closing remaining connections: %s", err.Error())
+				srv.Close()
+			}
+
+			err = <-served
+			if !errors.Is(err, server.ErrServerClosed) {
+				log.Fatalf("Server stopped: %s", err.Error())
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package gateway serves a JSON over HTTP interface to an ACS that only speaks SIP2. Each endpoint accepts a JSON object shaped like one of the request structs, sends it to the ACS over a pooled connection and answers with the decoded response struct as JSON.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+)
+
+type Config struct {
+	// Address is the host:port of the ACS.
+	Address string
+
+	// Client configures the connections to the ACS.
+	Client client.Config
+
+	// Each new connection logs in with these terminal credentials before it is used. An empty LoginUserID skips the SC Login.
+	LoginUserID   string
+	LoginPassword string
+	LocationCode  string
+
+	// InstitutionID and TerminalPassword are filled in on requests that leave them empty.
+	InstitutionID    string
+	TerminalPassword string
+
+	// MaxConnections is the number of connections to the ACS that may be open at once. Requests wait for a free connection for up to RequestTimeout seconds.
+	MaxConnections int
+	RequestTimeout int
+
+	// IdleTimeout is the number of seconds an unused connection is kept open for reuse. It should be shorter than the ACS's own idle timeout.
+	IdleTimeout int
+
+	// Logger receives the gateway's log output. If nil, logs are written as text to standard error.
+	Logger *slog.Logger
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Address:          "127.0.0.1:6001",
+		Client:           client.DefaultConfig(),
+		LoginUserID:      "",
+		LoginPassword:    "",
+		LocationCode:     "",
+		InstitutionID:    "",
+		TerminalPassword: "",
+		MaxConnections:   4,
+		RequestTimeout:   10,
+		IdleTimeout:      4,
+		Logger:           nil,
+	}
+}
+
+// routes maps each endpoint to the request it accepts.
+var routes = map[string]func() request.Request{
+	"/sc-status":          func() request.Request { return &request.SCStatus{} },
+	"/patron-status":      func() request.Request { return &request.PatronStatus{} },
+	"/patron-info":        func() request.Request { return &request.PatronInfo{} },
+	"/patron-enable":      func() request.Request { return &request.PatronEnable{} },
+	"/block-patron":       func() request.Request { return &request.BlockPatron{} },
+	"/end-patron-session": func() request.Request { return &request.EndPatronSession{} },
+	"/checkout":           func() request.Request { return &request.Checkout{} },
+	"/checkin":            func() request.Request { return &request.Checkin{} },
+	"/renew":              func() request.Request { return &request.Renew{} },
+	"/renew-all":          func() request.Request { return &request.RenewAll{} },
+	"/hold":               func() request.Request { return &request.Hold{} },
+	"/item-info":          func() request.Request { return &request.ItemInfo{} },
+	"/item-status-update": func() request.Request { return &request.ItemStatusUpdate{} },
+	"/fee-paid":           func() request.Request { return &request.FeePaid{} },
+}
+
+// maxBodyBytes limits the size of a JSON request body.
+const maxBodyBytes = 1 << 20
+
+// Gateway is an http.Handler translating JSON requests into SIP2 requests to the ACS. It is safe for concurrent use.
+type Gateway struct {
+	cfg    Config
+	logger *slog.Logger
+	pool   *client.Pool
+}
+
+func New(cfg Config) (*Gateway, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("invalid ACS address - must not be empty")
+	}
+
+	if cfg.MaxConnections < 1 {
+		return nil, fmt.Errorf("invalid max connections - must be greater than zero")
+	}
+
+	if cfg.RequestTimeout < 1 {
+		return nil, fmt.Errorf("invalid request timeout - must be greater than zero seconds.")
+	}
+
+	if cfg.IdleTimeout < 0 {
+		return nil, fmt.Errorf("invalid idle timeout - must not be negative.")
+	}
+
+	if cfg.Client.TerminatorCharacter == cfg.Client.DelimiterCharacter {
+		return nil, fmt.Errorf("cannot use the same character for both Terminator and Delimiter")
+	}
+
+	// Requests are validated before a connection to the ACS, and so a Client, may exist.
+	client.ConfigureCharacters(cfg.Client.DelimiterCharacter, cfg.Client.TerminatorCharacter)
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+
+	pool, err := client.NewPool(cfg.MaxConnections, time.Second*time.Duration(cfg.IdleTimeout), func() (*client.Client, error) {
+		return dialACS(cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gateway{
+		cfg:    cfg,
+		logger: logger,
+		pool:   pool,
+	}, nil
+}
+
+// Close closes the idle connections to the ACS. Requests still in progress finish and then close their connections.
+func (g *Gateway) Close() error {
+	return g.pool.Close()
+}
+
+// ServeHTTP answers POST requests to the endpoints in routes. Invalid requests are answered with 400 Bad Request, and failures talking to the ACS with 502 Bad Gateway or, if no connection became free in time, 503 Service Unavailable. Error bodies are JSON objects with an "error" field.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	newRequest, ok := routes[r.URL.Path]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown endpoint: "+r.URL.Path)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: "+r.Method)
+		return
+	}
+
+	req := newRequest()
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON request: "+err.Error())
+		return
+	}
+
+	g.setDefaults(req)
+
+	err = req.Validate()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*time.Duration(g.cfg.RequestTimeout))
+	defer cancel()
+
+	resp, err := g.pool.Send(ctx, req)
+	if err != nil {
+		logger := g.logger.With("path", r.URL.Path, "error", err)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			logger.Warn("no ACS connection available")
+			writeError(w, http.StatusServiceUnavailable, "no ACS connection available")
+			return
+		}
+		logger.Error("error sending SIP request to ACS")
+		writeError(w, http.StatusBadGateway, "error talking to ACS: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// setDefaults fills in the fields of req that are commonly left out of a JSON request: the Institution ID and Terminal Password from the Config, and the Transaction Date with the current time.
+func (g *Gateway) setDefaults(req request.Request) {
+	v := reflect.Indirect(reflect.ValueOf(req))
+
+	setIfZero := func(name string, value any) {
+		field := v.FieldByName(name)
+		if field.IsValid() && field.IsZero() {
+			field.Set(reflect.ValueOf(value))
+		}
+	}
+
+	setIfZero("InstitutionID", g.cfg.InstitutionID)
+	setIfZero("TerminalPassword", g.cfg.TerminalPassword)
+	setIfZero("TransactionDate", time.Now())
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+// dialACS connects to the ACS and logs in with the configured terminal credentials.
+func dialACS(cfg Config) (*client.Client, error) {
+	if cfg.LoginUserID == "" {
+		return client.Dial(cfg.Address, cfg.Client)
+	}
+
+	return client.DialLogin(cfg.Address, cfg.Client, &request.SCLogin{
+		LoginUserID:   cfg.LoginUserID,
+		LoginPassword: cfg.LoginPassword,
+		LocationCode:  cfg.LocationCode,
+	})
+}
@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/server"
+)
+
+// testACS starts a server.Server acting as the ACS and returns its address.
+func testACS(t *testing.T, cfg server.Config) (*server.Server, string) {
+	t.Helper()
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: r.LoginUserID == "gateway" && r.LoginPassword == "secret"}, nil
+	})
+
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		return &response.PatronInfo{
+			TransactionDate: time.Now(),
+			InstitutionID:   r.InstitutionID,
+			PatronID:        r.PatronID,
+			PatronName:      "Doe, John",
+			ValidPatron:     true,
+			ScreenMessage:   server.SessionFromContext(ctx).LoginUserID(),
+		}, nil
+	})
+
+	srv.HandleCheckout(func(ctx context.Context, r *request.Checkout) (*response.Checkout, error) {
+		return &response.Checkout{
+			Ok:              r.ItemID != "missing",
+			TransactionDate: time.Now(),
+			InstitutionID:   r.InstitutionID,
+			PatronID:        r.PatronID,
+			ItemID:          r.ItemID,
+			TitleID:         "Go Programming",
+			DueDate:         "2030-01-01",
+		}, nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	return srv, listener.Addr().String()
+}
+
+func post(t *testing.T, h http.Handler, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data)))
+	return rec
+}
+
+func TestGateway(t *testing.T) {
+	acsCfg := server.DefaultConfig()
+	acsCfg.RequireLogin = true
+	acsCfg.ConnectionTimeout = 1
+	_, addr := testACS(t, acsCfg)
+
+	cfg := DefaultConfig()
+	cfg.Address = addr
+	cfg.LoginUserID = "gateway"
+	cfg.LoginPassword = "secret"
+	cfg.InstitutionID = "inst"
+	cfg.MaxConnections = 2
+	cfg.IdleTimeout = 10
+
+	g, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	rec := post(t, g, "/patron-info", map[string]any{"PatronID": "johndoe"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var info response.PatronInfo
+	err = json.Unmarshal(rec.Body.Bytes(), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PatronName != "Doe, John" || info.InstitutionID != "inst" || !info.ValidPatron || info.ScreenMessage != "gateway" {
+		t.Fatalf("unexpected patron info: %#v", info)
+	}
+
+	// The ACS closes the pooled connection after its one second idle timeout, and the gateway reconnects.
+	time.Sleep(1500 * time.Millisecond)
+
+	rec = post(t, g, "/checkout", map[string]any{"PatronID": "johndoe", "ItemID": "item-1", "InstitutionID": "branch", "NBDueDate": time.Now()})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var checkout response.Checkout
+	err = json.Unmarshal(rec.Body.Bytes(), &checkout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkout.Ok || checkout.ItemID != "item-1" || checkout.InstitutionID != "branch" {
+		t.Fatalf("unexpected checkout: %#v", checkout)
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/unknown", `{}`, http.StatusNotFound},
+		{http.MethodGet, "/checkout", ``, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/checkout", `{"PatronID": `, http.StatusBadRequest},
+		{http.MethodPost, "/checkout", `{"Patron": "johndoe"}`, http.StatusBadRequest},
+		{http.MethodPost, "/checkout", `{"ItemID": "item-1"}`, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body)))
+		if rec.Code != tc.status {
+			t.Errorf("%s %s %s: status %d, expected %d: %s", tc.method, tc.path, tc.body, rec.Code, tc.status, rec.Body.String())
+		}
+	}
+}
+
+func TestGatewayLoginRefused(t *testing.T) {
+	_, addr := testACS(t, server.DefaultConfig())
+
+	cfg := DefaultConfig()
+	cfg.Address = addr
+	cfg.LoginUserID = "gateway"
+	cfg.LoginPassword = "wrong"
+	cfg.InstitutionID = "inst"
+
+	g, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	rec := post(t, g, "/patron-info", map[string]any{"PatronID": "johndoe"})
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status %d, expected %d: %s", rec.Code, http.StatusBadGateway, rec.Body.String())
+	}
+}
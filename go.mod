@@ -2,15 +2,20 @@ module github.com/pescew/sip
 
 go 1.22.3
 
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/go-playground/validator/v10 v10.22.0
+	golang.org/x/crypto v0.21.0
+	golang.org/x/net v0.23.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
-	github.com/go-playground/validator/v10 v10.22.0 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
-	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,263 @@
+// Package message converts SIP messages to and from the JSON form used by Evergreen's SIP2Mediator and other ACS stacks, for example:
+//
+//	{"code":"93","fixed_fields":["0","0"],"fields":[{"CN":"kiosk"},{"CO":"secret"}]}
+//
+// The fixed length part of a message is split into one string per fixed field, and each variable length field becomes an object with its two character code as the only key. Messages with a code this package does not know the layout of are kept in a raw form, with the whole fixed part and variable fields as one fixed field, so they still round-trip unchanged.
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+var ErrInvalidMessage = fmt.Errorf("Invalid SIP message")
+
+// Message is a SIP message in SIP2Mediator JSON form.
+type Message struct {
+	Code        string   `json:"code"`
+	FixedFields []string `json:"fixed_fields"`
+	Fields      []Field  `json:"fields"`
+}
+
+// Field is a variable length field, written in JSON as {"AO": "value"}.
+type Field struct {
+	Code  string
+	Value string
+}
+
+func (f Field) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{f.Code: f.Value})
+}
+
+func (f *Field) UnmarshalJSON(data []byte) error {
+	var m map[string]string
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return err
+	}
+	if len(m) != 1 {
+		return fmt.Errorf("%w: field must have exactly one code, got %s", ErrInvalidMessage, bytes.TrimSpace(data))
+	}
+	for code, value := range m {
+		f.Code, f.Value = code, value
+	}
+	return nil
+}
+
+// fixedFieldWidths are the widths of the fixed fields of each message code, in order.
+var fixedFieldWidths = map[string][]int{
+	// Requests:
+	"01": {1, 18},
+	"09": {1, 18, 18},
+	"11": {1, 1, 18, 18},
+	"15": {1, 18},
+	"17": {18},
+	"19": {18},
+	"23": {3, 18},
+	"25": {18},
+	"29": {1, 1, 18, 18},
+	"35": {18},
+	"37": {18, 2, 2, 3},
+	"63": {3, 18, 10},
+	"65": {18},
+	"93": {1, 1},
+	"97": {},
+	"99": {1, 3, 4},
+
+	// Responses:
+	"10": {1, 1, 1, 1, 18},
+	"12": {1, 1, 1, 1, 18},
+	"16": {1, 1, 18},
+	"18": {2, 2, 2, 18},
+	"20": {1, 18},
+	"24": {14, 3, 18},
+	"26": {14, 3, 18},
+	"30": {1, 1, 1, 1, 18},
+	"36": {1, 18},
+	"38": {1, 18},
+	"64": {14, 3, 18, 4, 4, 4, 4, 4, 4},
+	"66": {1, 4, 4, 18},
+	"94": {1},
+	"96": {},
+	"98": {1, 1, 1, 1, 1, 1, 3, 3, 18, 4},
+}
+
+// Known reports whether the fixed field layout of code is known, so that its messages are split into fields rather than kept in raw form.
+func Known(code string) bool {
+	_, ok := fixedFieldWidths[code]
+	return ok
+}
+
+// Unmarshal parses a SIP message line, with or without its terminator, into m. The AY sequence number and AZ checksum, if present, become fields like any other.
+func (m *Message) Unmarshal(line string, delimiter, terminator rune) error {
+	runes := []rune(strings.TrimSuffix(line, string(terminator)))
+	if len(runes) < 2 {
+		return ErrInvalidMessage
+	}
+
+	m.Code = string(runes[0:2])
+	m.FixedFields = []string{}
+	m.Fields = []Field{}
+	runes = runes[2:]
+
+	widths, ok := fixedFieldWidths[m.Code]
+	if !ok {
+		if len(runes) > 0 {
+			m.FixedFields = append(m.FixedFields, string(runes))
+		}
+		return nil
+	}
+
+	for _, width := range widths {
+		if len(runes) < width {
+			return fmt.Errorf("%w: message %s is too short for its fixed fields", ErrInvalidMessage, m.Code)
+		}
+		m.FixedFields = append(m.FixedFields, string(runes[:width]))
+		runes = runes[width:]
+	}
+
+	for _, segment := range strings.Split(string(runes), string(delimiter)) {
+		seg := []rune(segment)
+		if len(seg) == 0 {
+			continue
+		}
+		if len(seg) < 2 {
+			return fmt.Errorf("%w: invalid field %q in message %s", ErrInvalidMessage, segment, m.Code)
+		}
+
+		// The sequence number is not followed by a delimiter, so the checksum is in the same segment.
+		if code := string(seg[0:2]); code == "AY" && len(seg) >= 5 && string(seg[3:5]) == "AZ" {
+			m.Fields = append(m.Fields, Field{Code: "AY", Value: string(seg[2:3])}, Field{Code: "AZ", Value: string(seg[5:])})
+			continue
+		}
+		m.Fields = append(m.Fields, Field{Code: string(seg[0:2]), Value: string(seg[2:])})
+	}
+
+	return nil
+}
+
+// Marshal returns m as a SIP message line ending in terminator. Every field is followed by delimiter except AY and AZ, which are written last in the form "AY0AZ1234".
+func (m *Message) Marshal(delimiter, terminator rune) string {
+	var msg strings.Builder
+	msg.WriteString(m.Code)
+
+	for _, fixedField := range m.FixedFields {
+		msg.WriteString(fixedField)
+	}
+
+	var errorDetection strings.Builder
+	for _, field := range m.Fields {
+		if field.Code == "AY" || field.Code == "AZ" {
+			errorDetection.WriteString(field.Code + field.Value)
+			continue
+		}
+		fmt.Fprintf(&msg, "%s%s%c", field.Code, field.Value, delimiter)
+	}
+
+	msg.WriteString(errorDetection.String())
+	msg.WriteRune(terminator)
+	return msg.String()
+}
+
+// Validate checks that m can be written as a SIP message: the code and field codes are two characters, the fixed fields have the widths its code requires, and no value contains the delimiter or terminator.
+func (m *Message) Validate(delimiter, terminator rune) error {
+	badChars := string([]rune{delimiter, terminator})
+
+	if len([]rune(m.Code)) != 2 {
+		return fmt.Errorf("%w: invalid code %q", ErrInvalidMessage, m.Code)
+	}
+
+	if widths, ok := fixedFieldWidths[m.Code]; ok {
+		if len(m.FixedFields) != len(widths) {
+			return fmt.Errorf("%w: message %s has %d fixed fields, expected %d", ErrInvalidMessage, m.Code, len(m.FixedFields), len(widths))
+		}
+		for i, width := range widths {
+			if len([]rune(m.FixedFields[i])) != width {
+				return fmt.Errorf("%w: fixed field %d of message %s must be %d characters: %q", ErrInvalidMessage, i, m.Code, width, m.FixedFields[i])
+			}
+		}
+	}
+
+	for _, fixedField := range m.FixedFields {
+		if strings.ContainsAny(fixedField, string(terminator)) {
+			return fmt.Errorf("%w: fixed field of message %s contains the terminator", ErrInvalidMessage, m.Code)
+		}
+	}
+
+	for _, field := range m.Fields {
+		if len([]rune(field.Code)) != 2 {
+			return fmt.Errorf("%w: invalid field code %q in message %s", ErrInvalidMessage, field.Code, m.Code)
+		}
+		if strings.ContainsAny(field.Value, badChars) {
+			return fmt.Errorf("%w: field %s of message %s contains the delimiter or terminator", ErrInvalidMessage, field.Code, m.Code)
+		}
+	}
+
+	return nil
+}
+
+// Get returns the value of the first field with code, and whether there is one.
+func (m *Message) Get(code string) (string, bool) {
+	for _, field := range m.Fields {
+		if field.Code == code {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+// FromRequest converts req to a Message. Error detection fields are left out.
+func FromRequest(req request.Request, delimiter, terminator rune) (*Message, error) {
+	m := &Message{}
+	err := m.Unmarshal(req.Marshal(delimiter, terminator, false), delimiter, terminator)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FromResponse converts resp to a Message. Error detection fields are left out.
+func FromResponse(resp response.Response, delimiter, terminator rune) (*Message, error) {
+	m := &Message{}
+	err := m.Unmarshal(resp.Marshal(delimiter, terminator, false), delimiter, terminator)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Request converts m to the request type for its code. If m has error detection fields, they are checked as request.UnmarshalVerified does.
+func (m *Message) Request(delimiter, terminator rune) (request.Request, error) {
+	line, err := m.line(delimiter, terminator)
+	if err != nil {
+		return nil, err
+	}
+
+	req, _, _, err := request.UnmarshalVerified(line, delimiter, terminator)
+	return req, err
+}
+
+// Response converts m to the response type for its code. If m has error detection fields, they are checked as response.UnmarshalVerified does.
+func (m *Message) Response(delimiter, terminator rune) (response.Response, error) {
+	line, err := m.line(delimiter, terminator)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, _, _, err := response.UnmarshalVerified(line, delimiter, terminator)
+	return resp, err
+}
+
+// line validates m and returns it as a SIP message line without the terminator, as the Unmarshal functions expect.
+func (m *Message) line(delimiter, terminator rune) (string, error) {
+	err := m.Validate(delimiter, terminator)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(m.Marshal(delimiter, terminator), string(terminator)), nil
+}
@@ -0,0 +1,209 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pescew/sip/fields"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/utils"
+)
+
+const (
+	delimiter  = '|'
+	terminator = '\r'
+)
+
+func init() {
+	utils.ConfigureEscapeCharacters(delimiter, terminator)
+	request.InitValidator(delimiter, terminator)
+	response.InitValidator(delimiter, terminator)
+}
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	requests := []request.Request{
+		&request.BlockPatron{CardRetained: true, TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123", BlockedCardMsg: "lost"},
+		&request.Checkin{NoBlock: true, TransactionDate: now, ReturnDate: now, CurrentLocation: "desk", InstitutionID: "main-library", ItemID: "item-000001"},
+		&request.Checkout{SCRenewalPolicy: true, TransactionDate: now, NBDueDate: now, InstitutionID: "main-library", PatronID: "patron-000123", ItemID: "item-000001", PatronPassword: "1234"},
+		&request.Hold{HoldMode: "+", TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123", ItemID: "item-000001"},
+		&request.ItemInfo{TransactionDate: now, InstitutionID: "main-library", ItemID: "item-000001"},
+		&request.ItemStatusUpdate{TransactionDate: now, InstitutionID: "main-library", ItemID: "item-000001", ItemProperties: "fragile"},
+		&request.PatronStatus{Language: 1, TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123"},
+		&request.PatronEnable{TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123", TerminalPassword: "terminal", PatronPassword: "1234"},
+		&request.Renew{ThirdPartyAllowed: true, NoBlock: true, TransactionDate: now, NBDueDate: now, InstitutionID: "main-library", PatronID: "patron-000123", ItemID: "item-000001"},
+		&request.EndPatronSession{TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123", TerminalPassword: "terminal"},
+		&request.FeePaid{TransactionDate: now, FeeType: 1, PaymentType: 2, CurrencyType: "USD", FeeAmount: "1.50", InstitutionID: "main-library", PatronID: "patron-000123"},
+		&request.PatronInfo{Language: 1, TransactionDate: now, Summary: fields.Summary{OverdueItems: true}, InstitutionID: "main-library", PatronID: "patron-000123"},
+		&request.RenewAll{TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123"},
+		&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret", LocationCode: "lobby"},
+		&request.ACSResend{},
+		&request.SCStatus{StatusCode: 1, MaxPrintWidth: 40, ProtocolVersion: "2.00"},
+	}
+
+	for _, req := range requests {
+		m, err := FromRequest(req, delimiter, terminator)
+		if err != nil {
+			t.Fatalf("%T: %v", req, err)
+		}
+		if !Known(m.Code) {
+			t.Fatalf("%T: unknown code %s", req, m.Code)
+		}
+
+		parsed, err := roundTripJSON(t, m).Request(delimiter, terminator)
+		if err != nil {
+			t.Fatalf("%T: %v", req, err)
+		}
+
+		expected := req.Marshal(delimiter, terminator, false)
+		if line := parsed.Marshal(delimiter, terminator, false); line != expected {
+			t.Errorf("%T: got %q, expected %q", req, line, expected)
+		}
+	}
+
+	responses := []response.Response{
+		&response.Checkin{Ok: true, Alert: true, TransactionDate: now, InstitutionID: "main-library", ItemID: "item-000001", PermanentLocation: "stacks"},
+		&response.Checkout{Ok: true, TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123", ItemID: "item-000001", TitleID: "Go", DueDate: "2030-01-01"},
+		&response.Hold{Ok: true, Available: true, TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123"},
+		&response.ItemInfo{CirculationStatus: 3, SecurityMarker: 2, FeeType: 1, TransactionDate: now, HoldQueueLength: -1, DueDate: "2030-01-01", ItemID: "item-000001", TitleID: "Go"},
+		&response.ItemStatusUpdate{ItemPropertiesOk: true, TransactionDate: now, ItemID: "item-000001"},
+		&response.PatronStatus{PatronStatus: fields.PatronStatus{DenyCharges: true}, Language: 1, TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123", PatronName: "Doe, John"},
+		&response.PatronEnable{Language: 1, TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123", PatronName: "Doe, John"},
+		&response.Renew{Ok: true, RenewalOk: true, TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123", ItemID: "item-000001", TitleID: "Go", DueDate: "2030-01-01"},
+		&response.EndSession{EndSession: true, TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123"},
+		&response.FeePaid{PaymentAccepted: true, TransactionDate: now, InstitutionID: "main-library", PatronID: "patron-000123"},
+		&response.PatronInfo{Language: 1, TransactionDate: now, HoldItemsCount: 2, InstitutionID: "main-library", PatronID: "patron-000123", PatronName: "Doe, John", HoldItems: []string{"item-000001", "item-2"}},
+		&response.RenewAll{Ok: true, RenewedCount: 1, TransactionDate: now, InstitutionID: "main-library", RenewedItems: []string{"item-000001"}},
+		&response.SCLogin{Ok: true},
+		&response.SCResend{},
+		&response.ACSStatus{OnlineStatus: true, TimeoutPeriod: 30, RetriesAllowed: 3, DateTimeSync: now, ProtocolVersion: "2.00", InstitutionID: "main-library", LibraryName: "Library"},
+	}
+
+	for _, resp := range responses {
+		m, err := FromResponse(resp, delimiter, terminator)
+		if err != nil {
+			t.Fatalf("%T: %v", resp, err)
+		}
+		if !Known(m.Code) {
+			t.Fatalf("%T: unknown code %s", resp, m.Code)
+		}
+
+		parsed, err := roundTripJSON(t, m).Response(delimiter, terminator)
+		if err != nil {
+			t.Fatalf("%T: %v", resp, err)
+		}
+
+		expected := resp.Marshal(delimiter, terminator, false)
+		if line := parsed.Marshal(delimiter, terminator, false); line != expected {
+			t.Errorf("%T: got %q, expected %q", resp, line, expected)
+		}
+	}
+}
+
+// roundTripJSON encodes m as JSON and decodes it again.
+func roundTripJSON(t *testing.T, m *Message) *Message {
+	t.Helper()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Message
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &decoded
+}
+
+func TestJSONForm(t *testing.T) {
+	data := []byte(`{"code":"93","fixed_fields":["0","0"],"fields":[{"CN":"kiosk"},{"CO":"secret"},{"CP":"lobby"},{"AY":"1"},{"AZ":"F1E2"}]}`)
+
+	var m Message
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user, _ := m.Get("CN"); user != "kiosk" {
+		t.Fatalf("CN is %q", user)
+	}
+
+	line := m.Marshal(delimiter, terminator)
+	if line != "9300CNkiosk|COsecret|CPlobby|AY1AZF1E2\r" {
+		t.Fatalf("unexpected line %q", line)
+	}
+
+	// The checksum above is wrong, so converting the message is refused.
+	_, err = m.Request(delimiter, terminator)
+	if !errors.Is(err, utils.ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	var reparsed Message
+	err = reparsed.Unmarshal(line, delimiter, terminator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(&m, &reparsed) {
+		t.Fatalf("round trip changed the message:\n%s", cmp.Diff(&m, &reparsed))
+	}
+
+	m.Fields = m.Fields[:3]
+	req, err := m.Request(delimiter, terminator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login, ok := req.(*request.SCLogin); !ok || login.LoginUserID != "kiosk" || login.LoginPassword != "secret" || login.LocationCode != "lobby" {
+		t.Fatalf("unexpected request %#v", req)
+	}
+
+	err = json.Unmarshal([]byte(`{"code":"93","fixed_fields":["0","0"],"fields":[{"CN":"kiosk","CO":"secret"}]}`), &m)
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected a field with two codes to be refused, got %v", err)
+	}
+
+	m = Message{Code: "93", FixedFields: []string{"00"}, Fields: []Field{{"CN", "kiosk"}}}
+	_, err = m.Request(delimiter, terminator)
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected the wrong fixed fields to be refused, got %v", err)
+	}
+
+	m = Message{Code: "93", FixedFields: []string{"0", "0"}, Fields: []Field{{"CN", "kiosk|CO"}}}
+	_, err = m.Request(delimiter, terminator)
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected a value containing the delimiter to be refused, got %v", err)
+	}
+}
+
+func TestRawMessage(t *testing.T) {
+	line := "XX0123 fixed and|ZZvariable|\r"
+
+	var m Message
+	err := m.Unmarshal(line, delimiter, terminator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Code != "XX" || len(m.FixedFields) != 1 || len(m.Fields) != 0 || Known(m.Code) {
+		t.Fatalf("unexpected raw message %#v", m)
+	}
+
+	data, err := json.Marshal(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"code":"XX","fixed_fields":["0123 fixed and|ZZvariable|"],"fields":[]}` {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+
+	if got := roundTripJSON(t, &m).Marshal(delimiter, terminator); got != line {
+		t.Fatalf("got %q, expected %q", got, line)
+	}
+}
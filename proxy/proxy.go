@@ -0,0 +1,389 @@
+// Package proxy is a SIP2 proxy that sits between SCs and an ACS. SCs connect to it as they would to the ACS; each request is decoded, passed through any rewrite middleware and forwarded to the ACS over a pooled connection that the proxy logged in with its own terminal account, and the response is relayed back. This lets SCs move to a different ACS without being reconfigured. During such a move the proxy can also send every request to the new ACS as a shadow and report how its answers differ, see Config.ShadowAddress.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/server"
+	"github.com/pescew/sip/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Config struct {
+	// Server configures the listener SCs connect to.
+	Server server.Config
+
+	// Address is the host:port of the ACS.
+	Address string
+
+	// Client configures the connections to the ACS. Its Terminator and Delimiter must match those of Server.
+	Client client.Config
+
+	// The terminal credentials the proxy logs in to the ACS with for SCs that have no entry in Accounts. An empty LoginUserID skips the SC Login.
+	LoginUserID   string
+	LoginPassword string
+	LocationCode  string
+
+	// Accounts maps the Login User ID an SC logs in with to the account the proxy uses for it upstream. Login User IDs are matched case-insensitively, as they are by server.AccountTable.
+	Accounts map[string]Account
+
+	// MaxConnections is the number of connections to the ACS that may be open at once for each upstream account. Requests wait for a free connection for up to RequestTimeout seconds.
+	MaxConnections int
+	RequestTimeout int
+
+	// IdleTimeout is the number of seconds an unused connection is kept open for reuse. It should be shorter than the ACS's own idle timeout.
+	IdleTimeout int
+
+	// ShadowAddress is the host:port of a second ACS, such as the one being migrated to, that forwarded requests are also sent to once the ACS at Address has answered them. Only the response from Address reaches the SC; how the two differ is passed to OnShadowDiff. Requests the ACS at Address failed to answer are not shadowed. At most MaxConnections requests are sent to the shadow ACS at a time; while that many are in progress further requests are not shadowed, and are counted by ShadowDropped instead. Empty disables it.
+	ShadowAddress string
+
+	// ShadowStateChanges also shadows requests that change state on the ACS, such as Checkout, Checkin, Renew and Fee Paid. They are then applied on both ACSs, so only enable it when the shadow ACS holds a copy of the data that may diverge. By default only the requests that are client.ReadOnly are shadowed.
+	ShadowStateChanges bool
+
+	// The terminal credentials the proxy logs in to the shadow ACS with. Accounts does not apply to it.
+	ShadowLoginUserID   string
+	ShadowLoginPassword string
+	ShadowLocationCode  string
+
+	// OnShadowDiff is called with the outcome of every request sent to the shadow ACS. If nil, differences and errors are logged as warnings.
+	OnShadowDiff func(ShadowDiff)
+
+	// Logger receives the proxy's own log output. If nil, it falls back to the Logger of Server, or text on standard error.
+	Logger *slog.Logger
+}
+
+// Account maps a terminal account SCs log in to the proxy with to the one the proxy logs in to the ACS with on their behalf.
+type Account struct {
+	// PasswordHash is a hash of the password the SC must log in with, see server.HashPassword. It is not checked when the Server has an Authenticator, which decides SC Logins instead.
+	PasswordHash string
+
+	// The terminal credentials sent to the ACS for the SC.
+	UpstreamLoginUserID   string
+	UpstreamLoginPassword string
+	UpstreamLocationCode  string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Server:         server.DefaultConfig(),
+		Address:        "127.0.0.1:6001",
+		Client:         client.DefaultConfig(),
+		LoginUserID:    "",
+		LoginPassword:  "",
+		LocationCode:   "",
+		Accounts:       nil,
+		MaxConnections: 4,
+		RequestTimeout: 10,
+		IdleTimeout:    4,
+
+		ShadowAddress:       "",
+		ShadowStateChanges:  false,
+		ShadowLoginUserID:   "",
+		ShadowLoginPassword: "",
+		ShadowLocationCode:  "",
+		OnShadowDiff:        nil,
+
+		Logger: nil,
+	}
+}
+
+// forwarded are the requests passed on to the ACS. SC Login is answered by the proxy itself and ACS Resend by the Server.
+var forwarded = []types.MsgType{
+	types.ReqBlockPatron,
+	types.ReqCheckin,
+	types.ReqCheckout,
+	types.ReqHold,
+	types.ReqItemInfo,
+	types.ReqItemStatusUpdate,
+	types.ReqPatronStatus,
+	types.ReqPatronEnable,
+	types.ReqRenew,
+	types.ReqEndPatronSession,
+	types.ReqFeePaid,
+	types.ReqPatronInfo,
+	types.ReqRenewAll,
+	types.ReqSCStatus,
+}
+
+// Proxy is a server.Server whose requests are forwarded to an ACS. Rewrite rules are added with Use, like any other middleware; see SetInstitutionID, PrefixBarcodes and ScreenMessage.
+type Proxy struct {
+	*server.Server
+
+	cfg    Config
+	logger *slog.Logger
+
+	// accounts is Config.Accounts keyed by lower case Login User ID.
+	accounts map[string]Account
+
+	// shadows tracks the requests being sent to the shadow ACS, of which there are at most cap(shadowSlots).
+	shadows       sync.WaitGroup
+	shadowSlots   chan struct{}
+	shadowDropped atomic.Int64
+
+	mu     sync.Mutex
+	pools  map[upstreamLogin]*client.Pool
+	closed bool
+}
+
+// upstreamLogin identifies the ACS a connection is made to and the terminal account it is logged in with.
+type upstreamLogin struct {
+	address       string
+	loginUserID   string
+	loginPassword string
+	locationCode  string
+}
+
+func New(cfg Config) (*Proxy, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("invalid ACS address - must not be empty")
+	}
+
+	if cfg.MaxConnections < 1 {
+		return nil, fmt.Errorf("invalid max connections - must be greater than zero")
+	}
+
+	if cfg.RequestTimeout < 1 {
+		return nil, fmt.Errorf("invalid request timeout - must be greater than zero seconds.")
+	}
+
+	if cfg.IdleTimeout < 0 {
+		return nil, fmt.Errorf("invalid idle timeout - must not be negative.")
+	}
+
+	if cfg.Client.TerminatorCharacter != cfg.Server.TerminatorCharacter || cfg.Client.DelimiterCharacter != cfg.Server.DelimiterCharacter {
+		return nil, fmt.Errorf("cannot use different Terminator or Delimiter characters for the Server and the Client")
+	}
+
+	accounts := make(map[string]Account, len(cfg.Accounts))
+	for loginUserID, account := range cfg.Accounts {
+		if cfg.Server.Authenticator == nil {
+			_, err := bcrypt.Cost([]byte(account.PasswordHash))
+			if err != nil {
+				return nil, fmt.Errorf("invalid password hash for account %s: %v", loginUserID, err)
+			}
+		}
+
+		key := strings.ToLower(loginUserID)
+		if _, dup := accounts[key]; dup {
+			return nil, fmt.Errorf("duplicate account %s - login user IDs are matched case-insensitively", loginUserID)
+		}
+		accounts[key] = account
+	}
+
+	srv, err := server.New(cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = cfg.Server.Logger
+	}
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+
+	p := &Proxy{
+		Server:   srv,
+		cfg:      cfg,
+		logger:   logger,
+		accounts: accounts,
+
+		shadowSlots: make(chan struct{}, cfg.MaxConnections),
+		pools:       make(map[upstreamLogin]*client.Pool),
+	}
+
+	for _, msgType := range forwarded {
+		srv.Handle(msgType, p.forward)
+	}
+	if cfg.Server.Authenticator == nil {
+		srv.HandleSCLogin(p.login)
+	}
+
+	return p, nil
+}
+
+// forward sends req to the ACS over a connection logged in with the upstream account of the SC's session, and returns the ACS's response.
+func (p *Proxy) forward(ctx context.Context, req request.Request) (response.Response, error) {
+	pool, err := p.pool(p.upstream(server.SessionFromContext(ctx)))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.cfg.RequestTimeout))
+	defer cancel()
+
+	// The request is stamped with the upstream sequence number while it is sent, and the response to the SC must carry the SC's own.
+	seqNum := req.GetSeqNum()
+	defer req.SetSeqNum(seqNum)
+
+	resp, err := pool.Send(ctx, req)
+
+	msgType := server.MsgTypeFromContext(ctx)
+	if p.cfg.ShadowAddress != "" && err == nil && resp != nil && (client.ReadOnly(req) || p.cfg.ShadowStateChanges) {
+		// Middleware may still change req and resp once they are returned.
+		p.startShadow(msgType, copyMessage(req), copyMessage(resp))
+	}
+
+	return resp, err
+}
+
+// startShadow sends req to the shadow ACS in the background, unless as many requests as it has connections are already in progress.
+func (p *Proxy) startShadow(msgType types.MsgType, req request.Request, resp response.Response) {
+	select {
+	case p.shadowSlots <- struct{}{}:
+	default:
+		p.shadowDropped.Add(1)
+		p.logger.Debug("shadow ACS busy, not shadowing request", "msg_type", msgType.String())
+		return
+	}
+
+	p.shadows.Add(1)
+	go func() {
+		defer func() {
+			<-p.shadowSlots
+			p.shadows.Done()
+		}()
+		p.shadow(msgType, req, resp)
+	}()
+}
+
+// ShadowDropped returns the number of requests that were not sent to the shadow ACS because it was busy with earlier ones.
+func (p *Proxy) ShadowDropped() int64 {
+	return p.shadowDropped.Load()
+}
+
+// login answers an SC Login when the Server has no Authenticator. SCs with an entry in Accounts must log in with the password matching its PasswordHash; any other login is checked by logging in to the ACS with it.
+func (p *Proxy) login(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+	if account, ok := p.accounts[strings.ToLower(r.LoginUserID)]; ok {
+		return &response.SCLogin{Ok: server.CheckPassword(account.PasswordHash, r.LoginPassword)}, nil
+	}
+
+	c, err := client.DialLogin(p.cfg.Address, p.cfg.Client, &request.SCLogin{
+		AlgorithmUserID:   r.AlgorithmUserID,
+		AlgorithmPassword: r.AlgorithmPassword,
+		LoginUserID:       r.LoginUserID,
+		LoginPassword:     r.LoginPassword,
+		LocationCode:      r.LocationCode,
+	})
+	if errors.Is(err, client.ErrLoginRefused) {
+		return &response.SCLogin{Ok: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Close()
+
+	return &response.SCLogin{Ok: true}, nil
+}
+
+// upstream returns the account the proxy logs in to the ACS with for session. It is looked up by the Login User ID of the Authenticator's account if the session has one, and otherwise by the one the SC logged in with.
+func (p *Proxy) upstream(session *server.Session) upstreamLogin {
+	if session != nil && session.LoggedIn() {
+		loginUserID := session.LoginUserID()
+		if account, ok := session.Account(); ok {
+			loginUserID = account.LoginUserID
+		}
+
+		if account, ok := p.accounts[strings.ToLower(loginUserID)]; ok {
+			return upstreamLogin{
+				address:       p.cfg.Address,
+				loginUserID:   account.UpstreamLoginUserID,
+				loginPassword: account.UpstreamLoginPassword,
+				locationCode:  account.UpstreamLocationCode,
+			}
+		}
+	}
+
+	return upstreamLogin{
+		address:       p.cfg.Address,
+		loginUserID:   p.cfg.LoginUserID,
+		loginPassword: p.cfg.LoginPassword,
+		locationCode:  p.cfg.LocationCode,
+	}
+}
+
+// shadowLogin returns the account the proxy logs in to the shadow ACS with.
+func (p *Proxy) shadowLogin() upstreamLogin {
+	return upstreamLogin{
+		address:       p.cfg.ShadowAddress,
+		loginUserID:   p.cfg.ShadowLoginUserID,
+		loginPassword: p.cfg.ShadowLoginPassword,
+		locationCode:  p.cfg.ShadowLocationCode,
+	}
+}
+
+// pool returns the pool of connections logged in with login, creating it on first use.
+func (p *Proxy) pool(login upstreamLogin) (*client.Pool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, server.ErrServerClosed
+	}
+
+	pool, ok := p.pools[login]
+	if ok {
+		return pool, nil
+	}
+
+	pool, err := client.NewPool(p.cfg.MaxConnections, time.Second*time.Duration(p.cfg.IdleTimeout), func() (*client.Client, error) {
+		return p.dial(login)
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.pools[login] = pool
+	return pool, nil
+}
+
+// dial connects to the ACS at login.address and logs in as login.
+func (p *Proxy) dial(login upstreamLogin) (*client.Client, error) {
+	if login.loginUserID == "" {
+		return client.Dial(login.address, p.cfg.Client)
+	}
+
+	return client.DialLogin(login.address, p.cfg.Client, &request.SCLogin{
+		LoginUserID:   login.loginUserID,
+		LoginPassword: login.loginPassword,
+		LocationCode:  login.locationCode,
+	})
+}
+
+// Shutdown gracefully shuts down the proxy as server.Server.Shutdown does and waits for the requests sent to the shadow ACS, then closes the connections to the ACS.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	err := p.Server.Shutdown(ctx)
+	if err == nil {
+		p.shadows.Wait()
+	}
+	p.closePools()
+	return err
+}
+
+// Close immediately closes all listeners and connections, both to SCs and to the ACS.
+func (p *Proxy) Close() error {
+	err := p.Server.Close()
+	p.closePools()
+	return err
+}
+
+func (p *Proxy) closePools() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, pool := range p.pools {
+		pool.Close()
+	}
+}
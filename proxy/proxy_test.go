@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/server"
+)
+
+// testACS starts a server.Server acting as the ACS and returns its address. Its responses carry the terminal account they were sent over and the IDs the ACS received, and patrons are named patronName.
+func testACS(t *testing.T, patronName string) string {
+	t.Helper()
+
+	cfg := server.DefaultConfig()
+	cfg.RequireLogin = true
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwords := map[string]string{"proxy": "secret", "upstream-kiosk": "upstream", "local": "local-secret"}
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		password, ok := passwords[r.LoginUserID]
+		return &response.SCLogin{Ok: ok && r.LoginPassword == password}, nil
+	})
+
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		return &response.PatronInfo{
+			TransactionDate: time.Now(),
+			InstitutionID:   r.InstitutionID,
+			PatronID:        r.PatronID,
+			PatronName:      patronName,
+			ValidPatron:     true,
+			ScreenMessage:   server.SessionFromContext(ctx).LoginUserID(),
+			PrintLine:       r.InstitutionID + " " + r.PatronID,
+		}, nil
+	})
+
+	srv.HandleCheckout(func(ctx context.Context, r *request.Checkout) (*response.Checkout, error) {
+		return &response.Checkout{
+			Ok:              true,
+			TransactionDate: time.Now(),
+			InstitutionID:   r.InstitutionID,
+			PatronID:        r.PatronID,
+			ItemID:          r.ItemID,
+			TitleID:         "Go Programming",
+			DueDate:         "2030-01-01",
+			PrintLine:       r.ItemID,
+		}, nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	return listener.Addr().String()
+}
+
+// testProxy starts p and returns a client connected to it.
+func testProxy(t *testing.T, p *Proxy) func() *client.Client {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(listener)
+	t.Cleanup(func() {
+		p.Close()
+	})
+
+	return func() *client.Client {
+		c, err := client.Dial(listener.Addr().String(), client.DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			c.Close()
+		})
+		return c
+	}
+}
+
+func TestProxy(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Server.RequireLogin = true
+	cfg.Address = testACS(t, "Doe, John")
+	cfg.LoginUserID = "proxy"
+	cfg.LoginPassword = "secret"
+	hash, err := server.HashPassword("kiosk-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Accounts = map[string]Account{
+		"kiosk": {PasswordHash: hash, UpstreamLoginUserID: "upstream-kiosk", UpstreamLoginPassword: "upstream"},
+	}
+
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Use(SetInstitutionID("new"), PrefixBarcodes("P", "I"), ScreenMessage("via proxy"))
+	dial := testProxy(t, p)
+
+	for _, tc := range []struct {
+		loginUserID   string
+		loginPassword string
+		ok            bool
+		upstream      string
+	}{
+		{"kiosk", "kiosk-secret", true, "upstream-kiosk"},
+		{"KIOSK", "kiosk-secret", true, "upstream-kiosk"},
+		{"kiosk", "upstream", false, ""},
+		{"local", "local-secret", true, "proxy"},
+		{"local", "wrong", false, ""},
+	} {
+		c := dial()
+
+		login, err := c.Login(&request.SCLogin{LoginUserID: tc.loginUserID, LoginPassword: tc.loginPassword})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if login.Ok != tc.ok {
+			t.Errorf("login as %s with %s: Ok %t, expected %t", tc.loginUserID, tc.loginPassword, login.Ok, tc.ok)
+		}
+		if !tc.ok {
+			continue
+		}
+
+		info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "old", PatronID: "123"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.PrintLine != "new P123" {
+			t.Errorf("ACS received %q, expected the rewritten IDs", info.PrintLine)
+		}
+		if info.InstitutionID != "old" || info.PatronID != "123" || info.PatronName != "Doe, John" {
+			t.Errorf("unexpected patron info: %#v", info)
+		}
+		if expected := tc.upstream + " via proxy"; info.ScreenMessage != expected {
+			t.Errorf("screen message %q, expected %q", info.ScreenMessage, expected)
+		}
+
+		checkout, err := c.Checkout(&request.Checkout{TransactionDate: time.Now(), NBDueDate: time.Now(), InstitutionID: "old", PatronID: "123", ItemID: "456"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !checkout.Ok || checkout.ItemID != "456" || checkout.PrintLine != "I456" {
+			t.Errorf("unexpected checkout: %#v", checkout)
+		}
+	}
+}
+
+func TestProxyAccounts(t *testing.T) {
+	hash, err := server.HashPassword("kiosk-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Server.RequireLogin = true
+	cfg.Address = testACS(t, "Doe, John")
+	cfg.LoginUserID = "proxy"
+	cfg.LoginPassword = "secret"
+	cfg.Server.Authenticator, err = server.NewAccountTable(server.Account{LoginUserID: "Kiosk-01", PasswordHash: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Accounts = map[string]Account{
+		"kiosk-01": {UpstreamLoginUserID: "upstream-kiosk", UpstreamLoginPassword: "upstream"},
+	}
+
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testProxy(t, p)()
+
+	// The Authenticator matches the Login User ID case-insensitively, and so must the upstream account mapping.
+	login, err := c.Login(&request.SCLogin{LoginUserID: "KIOSK-01", LoginPassword: "kiosk-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !login.Ok {
+		t.Fatalf("login refused")
+	}
+
+	info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ScreenMessage != "upstream-kiosk" {
+		t.Fatalf("forwarded as %q, expected the mapped upstream account", info.ScreenMessage)
+	}
+
+	// Without an Authenticator the proxy checks the passwords itself, so every hash must be valid.
+	cfg.Server.Authenticator = nil
+	cfg.Accounts = map[string]Account{
+		"kiosk-01": {PasswordHash: "kiosk-secret", UpstreamLoginUserID: "upstream-kiosk", UpstreamLoginPassword: "upstream"},
+	}
+	_, err = New(cfg)
+	if err == nil {
+		t.Fatalf("expected an error for a password that is not a bcrypt hash")
+	}
+
+	cfg.Accounts = map[string]Account{
+		"kiosk-01": {PasswordHash: hash},
+		"KIOSK-01": {PasswordHash: hash},
+	}
+	_, err = New(cfg)
+	if err == nil {
+		t.Fatalf("expected an error for accounts differing only in case")
+	}
+}
+
+func TestProxyUpstreamLoginRefused(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Server.SendErrorResponses = true
+	cfg.Address = testACS(t, "Doe, John")
+	cfg.LoginUserID = "proxy"
+	cfg.LoginPassword = "wrong"
+
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testProxy(t, p)()
+
+	info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ValidPatron || info.ScreenMessage != cfg.Server.ErrorScreenMessage {
+		t.Fatalf("expected an error response, got %#v", info)
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/server"
+)
+
+// SetInstitutionID returns middleware that replaces the AO Institution ID of every request with institutionID before it is forwarded, and puts the one sent by the SC back into the response.
+func SetInstitutionID(institutionID string) server.Middleware {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req request.Request) (response.Response, error) {
+			original, ok := rewriteField(req, "InstitutionID", func(string) string {
+				return institutionID
+			})
+
+			resp, err := next(ctx, req)
+			if ok && resp != nil {
+				rewriteField(resp, "InstitutionID", func(string) string {
+					return original
+				})
+			}
+			return resp, err
+		}
+	}
+}
+
+// PrefixBarcodes returns middleware that adds patronPrefix to the AA Patron ID and itemPrefix to the AB Item ID of every request before it is forwarded, and removes them again from the response, for an ACS whose barcodes differ from the ones printed on cards and items.
+func PrefixBarcodes(patronPrefix, itemPrefix string) server.Middleware {
+	addPrefix := func(prefix string) func(string) string {
+		return func(v string) string {
+			if v == "" {
+				return v
+			}
+			return prefix + v
+		}
+	}
+	trimPrefix := func(prefix string) func(string) string {
+		return func(v string) string {
+			return strings.TrimPrefix(v, prefix)
+		}
+	}
+
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req request.Request) (response.Response, error) {
+			rewriteField(req, "PatronID", addPrefix(patronPrefix))
+			rewriteField(req, "ItemID", addPrefix(itemPrefix))
+
+			resp, err := next(ctx, req)
+			if resp != nil {
+				rewriteField(resp, "PatronID", trimPrefix(patronPrefix))
+				rewriteField(resp, "ItemID", trimPrefix(itemPrefix))
+			}
+			return resp, err
+		}
+	}
+}
+
+// ScreenMessage returns middleware that adds msg to the AF Screen Message of every response that has one, after any message from the ACS.
+func ScreenMessage(msg string) server.Middleware {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req request.Request) (response.Response, error) {
+			resp, err := next(ctx, req)
+			if resp != nil {
+				rewriteField(resp, "ScreenMessage", func(v string) string {
+					if v == "" {
+						return msg
+					}
+					return v + " " + msg
+				})
+			}
+			return resp, err
+		}
+	}
+}
+
+// rewriteField replaces the string field name of the struct msg points to with the result of rewrite. It returns the previous value, and false if msg has no such field.
+func rewriteField(msg any, name string, rewrite func(string) string) (string, bool) {
+	v := reflect.Indirect(reflect.ValueOf(msg))
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+	field := v.FieldByName(name)
+	if field.Kind() != reflect.String || !field.CanSet() {
+		return "", false
+	}
+
+	previous := field.String()
+	field.SetString(rewrite(previous))
+	return previous, true
+}
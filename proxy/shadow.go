@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/types"
+)
+
+// ShadowDiff is the outcome of sending a forwarded request to the shadow ACS as well, see Config.ShadowAddress.
+type ShadowDiff struct {
+	MsgType types.MsgType
+	Request request.Request
+
+	// Response is the response from the ACS that was returned to the SC.
+	Response response.Response
+
+	// ShadowResponse is the response from the shadow ACS. If it could not be obtained Err is set instead.
+	ShadowResponse response.Response
+	Err            error
+
+	// Differences lists the fields that differ between Response and ShadowResponse, ignoring dates, transaction IDs and sequence numbers. It is empty when both ACSs answered alike.
+	Differences []FieldDiff
+}
+
+// FieldDiff is a field whose value differs between the two responses. Field is the struct field name, for example "PatronStatus.DenyCharges", or "type" if the ACSs answered with different message types.
+type FieldDiff struct {
+	Field    string
+	Response string
+	Shadow   string
+}
+
+func (fd FieldDiff) String() string {
+	return fmt.Sprintf("%s: %q != %q", fd.Field, fd.Response, fd.Shadow)
+}
+
+// ignoredFields are the response fields expected to differ between two ACSs answering the same request. Fields of type time.Time are ignored as well.
+var ignoredFields = map[string]bool{
+	"DueDate":       true,
+	"TransactionID": true,
+	"SeqNum":        true,
+}
+
+// shadow sends req to the shadow ACS and reports how its response differs from resp, the one the SC was answered with. It runs after the SC has been answered, so the shadow ACS never delays it.
+func (p *Proxy) shadow(msgType types.MsgType, req request.Request, resp response.Response) {
+	diff := ShadowDiff{
+		MsgType:  msgType,
+		Request:  req,
+		Response: resp,
+	}
+
+	pool, err := p.pool(p.shadowLogin())
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(p.cfg.RequestTimeout))
+		diff.ShadowResponse, err = pool.Send(ctx, req)
+		cancel()
+	}
+
+	if err != nil {
+		diff.Err = err
+	} else {
+		diff.Differences = diffResponses(resp, diff.ShadowResponse)
+	}
+
+	if p.cfg.OnShadowDiff != nil {
+		p.cfg.OnShadowDiff(diff)
+		return
+	}
+
+	switch {
+	case diff.Err != nil:
+		p.logger.Warn("error sending SIP request to shadow ACS", "msg_type", msgType.String(), "error", diff.Err)
+	case len(diff.Differences) > 0:
+		p.logger.Warn("shadow ACS answered differently", "msg_type", msgType.String(), "differences", diff.Differences)
+	default:
+		p.logger.Debug("shadow ACS answered alike", "msg_type", msgType.String())
+	}
+}
+
+// diffResponses compares a and b field by field.
+func diffResponses(a, b response.Response) []FieldDiff {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return []FieldDiff{{Field: "type", Response: fmt.Sprintf("%T", a), Shadow: fmt.Sprintf("%T", b)}}
+	}
+	if a == nil {
+		return nil
+	}
+
+	var diffs []FieldDiff
+	diffStructs("", reflect.Indirect(reflect.ValueOf(a)), reflect.Indirect(reflect.ValueOf(b)), &diffs)
+	return diffs
+}
+
+// diffStructs appends the fields that differ between the structs a and b to diffs, descending into nested structs such as fields.PatronStatus.
+func diffStructs(prefix string, a, b reflect.Value, diffs *[]FieldDiff) {
+	if a.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*diffs = append(*diffs, FieldDiff{Field: prefix, Response: fmt.Sprint(a.Interface()), Shadow: fmt.Sprint(b.Interface())})
+		}
+		return
+	}
+
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		if !field.IsExported() || ignoredFields[field.Name] || field.Type == reflect.TypeOf(time.Time{}) {
+			continue
+		}
+
+		name := field.Name
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		diffStructs(name, a.Field(i), b.Field(i), diffs)
+	}
+}
+
+// copyMessage returns a shallow copy of the struct msg points to, so that it can be used after the original has been changed. The request and response structs have no fields that are changed in place.
+func copyMessage[T any](msg T) T {
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return msg
+	}
+
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	return c.Interface().(T)
+}
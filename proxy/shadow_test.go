@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pescew/sip/fields"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/types"
+)
+
+func TestShadow(t *testing.T) {
+	diffs := make(chan ShadowDiff, 2)
+
+	cfg := DefaultConfig()
+	cfg.Address = testACS(t, "Doe, John")
+	cfg.LoginUserID = "proxy"
+	cfg.LoginPassword = "secret"
+	cfg.ShadowAddress = testACS(t, "John Doe")
+	cfg.ShadowLoginUserID = "proxy"
+	cfg.ShadowLoginPassword = "secret"
+	cfg.OnShadowDiff = func(diff ShadowDiff) {
+		diffs <- diff
+	}
+
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Use(ScreenMessage("via proxy"))
+	c := testProxy(t, p)()
+
+	info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PatronName != "Doe, John" || info.ScreenMessage != "proxy via proxy" {
+		t.Fatalf("expected the response of the ACS, got %#v", info)
+	}
+
+	diff := <-diffs
+	if diff.Err != nil {
+		t.Fatal(diff.Err)
+	}
+	if diff.MsgType != types.ReqPatronInfo {
+		t.Errorf("message type %s", diff.MsgType)
+	}
+	if expected := []FieldDiff{{Field: "PatronName", Response: "Doe, John", Shadow: "John Doe"}}; !cmp.Equal(diff.Differences, expected) {
+		t.Errorf("unexpected differences:\n%s", cmp.Diff(expected, diff.Differences))
+	}
+
+	checkout := &request.Checkout{TransactionDate: time.Now(), NBDueDate: time.Now(), InstitutionID: "inst", PatronID: "123", ItemID: "456"}
+
+	// A Checkout changes state on the ACS, and is not shadowed by default.
+	_, err = c.Checkout(checkout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := <-diffs; diff.MsgType != types.ReqPatronInfo {
+		t.Fatalf("expected the Checkout not to be shadowed, got %s", diff.MsgType)
+	}
+
+	// The transaction dates differ, and are ignored.
+	p.cfg.ShadowStateChanges = true
+	_, err = c.Checkout(checkout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff = <-diffs
+	if diff.MsgType != types.ReqCheckout || diff.Err != nil || len(diff.Differences) != 0 {
+		t.Errorf("expected a Checkout without differences, got %s: %v, %v", diff.MsgType, diff.Differences, diff.Err)
+	}
+}
+
+func TestDiffResponses(t *testing.T) {
+	a := &response.Checkout{
+		Ok:              true,
+		TransactionDate: time.Now(),
+		InstitutionID:   "inst",
+		PatronID:        "123",
+		ItemID:          "456",
+		DueDate:         "2030-01-01",
+		TransactionID:   "1",
+		SeqNum:          1,
+	}
+	b := &response.Checkout{
+		Ok:              false,
+		TransactionDate: time.Now().Add(time.Minute),
+		InstitutionID:   "inst",
+		PatronID:        "123",
+		ItemID:          "456",
+		DueDate:         "01/01/2030",
+		TransactionID:   "2",
+		SeqNum:          2,
+	}
+
+	expected := []FieldDiff{{Field: "Ok", Response: "true", Shadow: "false"}}
+	if diffs := diffResponses(a, b); !cmp.Equal(diffs, expected) {
+		t.Errorf("unexpected differences:\n%s", cmp.Diff(expected, diffs))
+	}
+
+	expected = []FieldDiff{{Field: "PatronStatus.DenyRenewals", Response: "false", Shadow: "true"}}
+	diffs := diffResponses(
+		&response.PatronStatus{PatronStatus: fields.PatronStatus{DenyCharges: true}},
+		&response.PatronStatus{PatronStatus: fields.PatronStatus{DenyCharges: true, DenyRenewals: true}},
+	)
+	if !cmp.Equal(diffs, expected) {
+		t.Errorf("unexpected differences:\n%s", cmp.Diff(expected, diffs))
+	}
+
+	expected = []FieldDiff{{Field: "type", Response: "*response.Checkout", Shadow: "*response.SCResend"}}
+	if diffs := diffResponses(a, &response.SCResend{}); !cmp.Equal(diffs, expected) {
+		t.Errorf("unexpected differences:\n%s", cmp.Diff(expected, diffs))
+	}
+}
+
+func TestShadowBusy(t *testing.T) {
+	diffs := make(chan ShadowDiff)
+
+	cfg := DefaultConfig()
+	cfg.Address = testACS(t, "Doe, John")
+	cfg.LoginUserID = "proxy"
+	cfg.LoginPassword = "secret"
+	cfg.MaxConnections = 1
+	cfg.ShadowAddress = cfg.Address
+	cfg.ShadowLoginUserID = "proxy"
+	cfg.ShadowLoginPassword = "secret"
+	cfg.OnShadowDiff = func(diff ShadowDiff) {
+		diffs <- diff
+	}
+
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testProxy(t, p)()
+
+	// The first request holds the only shadow slot until its diff is received, so the second is dropped.
+	for i := 0; i < 2; i++ {
+		_, err = c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "123"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dropped := p.ShadowDropped(); dropped != 1 {
+		t.Fatalf("%d requests dropped, expected 1", dropped)
+	}
+	<-diffs
+}
@@ -40,9 +40,15 @@ func (ar *ACSResend) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (ar *ACSResend) Validate() error {
-	err := Validate.Struct(ar)
+	err := structValidator().Struct(ar)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqACSResend.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (ar *ACSResend) GetSeqNum() int {
+	return 0
+}
+
+func (ar *ACSResend) SetSeqNum(seqNum int) {}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -92,9 +93,21 @@ func (bp *BlockPatron) Unmarshal(line string, delimiter, terminator rune) error
 }
 
 func (bp *BlockPatron) Validate() error {
-	err := Validate.Struct(bp)
+	err := structValidator().Struct(bp)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqBlockPatron.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (bp *BlockPatron) GetSeqNum() int {
+	return bp.SeqNum
+}
+
+func (bp *BlockPatron) SetSeqNum(seqNum int) {
+	bp.SeqNum = seqNum
+}
+
+func (bp *BlockPatron) LogValue() slog.Value {
+	return redactedValue(bp)
+}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -118,9 +119,21 @@ func (ci *Checkin) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (ci *Checkin) Validate() error {
-	err := Validate.Struct(ci)
+	err := structValidator().Struct(ci)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqCheckin.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (ci *Checkin) GetSeqNum() int {
+	return ci.SeqNum
+}
+
+func (ci *Checkin) SetSeqNum(seqNum int) {
+	ci.SeqNum = seqNum
+}
+
+func (ci *Checkin) LogValue() slog.Value {
+	return redactedValue(ci)
+}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -135,9 +136,21 @@ func (co *Checkout) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (co *Checkout) Validate() error {
-	err := Validate.Struct(co)
+	err := structValidator().Struct(co)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqCheckout.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (co *Checkout) GetSeqNum() int {
+	return co.SeqNum
+}
+
+func (co *Checkout) SetSeqNum(seqNum int) {
+	co.SeqNum = seqNum
+}
+
+func (co *Checkout) LogValue() slog.Value {
+	return redactedValue(co)
+}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -94,9 +95,21 @@ func (eps *EndPatronSession) Unmarshal(line string, delimiter, terminator rune)
 }
 
 func (eps *EndPatronSession) Validate() error {
-	err := Validate.Struct(eps)
+	err := structValidator().Struct(eps)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqEndPatronSession.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (eps *EndPatronSession) GetSeqNum() int {
+	return eps.SeqNum
+}
+
+func (eps *EndPatronSession) SetSeqNum(seqNum int) {
+	eps.SeqNum = seqNum
+}
+
+func (eps *EndPatronSession) LogValue() slog.Value {
+	return redactedValue(eps)
+}
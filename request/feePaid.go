@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -128,9 +129,21 @@ func (fp *FeePaid) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (fp *FeePaid) Validate() error {
-	err := Validate.Struct(fp)
+	err := structValidator().Struct(fp)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqFeePaid.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (fp *FeePaid) GetSeqNum() int {
+	return fp.SeqNum
+}
+
+func (fp *FeePaid) SetSeqNum(seqNum int) {
+	fp.SeqNum = seqNum
+}
+
+func (fp *FeePaid) LogValue() slog.Value {
+	return redactedValue(fp)
+}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -156,9 +157,21 @@ func (h *Hold) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (h *Hold) Validate() error {
-	err := Validate.Struct(h)
+	err := structValidator().Struct(h)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqHold.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (h *Hold) GetSeqNum() int {
+	return h.SeqNum
+}
+
+func (h *Hold) SetSeqNum(seqNum int) {
+	h.SeqNum = seqNum
+}
+
+func (h *Hold) LogValue() slog.Value {
+	return redactedValue(h)
+}
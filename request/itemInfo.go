@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -88,9 +89,21 @@ func (ii *ItemInfo) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (ii *ItemInfo) Validate() error {
-	err := Validate.Struct(ii)
+	err := structValidator().Struct(ii)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqItemInfo.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (ii *ItemInfo) GetSeqNum() int {
+	return ii.SeqNum
+}
+
+func (ii *ItemInfo) SetSeqNum(seqNum int) {
+	ii.SeqNum = seqNum
+}
+
+func (ii *ItemInfo) LogValue() slog.Value {
+	return redactedValue(ii)
+}
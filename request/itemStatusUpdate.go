@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -94,9 +95,21 @@ func (isu *ItemStatusUpdate) Unmarshal(line string, delimiter, terminator rune)
 }
 
 func (isu *ItemStatusUpdate) Validate() error {
-	err := Validate.Struct(isu)
+	err := structValidator().Struct(isu)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqItemStatusUpdate.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (isu *ItemStatusUpdate) GetSeqNum() int {
+	return isu.SeqNum
+}
+
+func (isu *ItemStatusUpdate) SetSeqNum(seqNum int) {
+	isu.SeqNum = seqNum
+}
+
+func (isu *ItemStatusUpdate) LogValue() slog.Value {
+	return redactedValue(isu)
+}
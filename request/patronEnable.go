@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -94,9 +95,21 @@ func (pe *PatronEnable) Unmarshal(line string, delimiter, terminator rune) error
 }
 
 func (pe *PatronEnable) Validate() error {
-	err := Validate.Struct(pe)
+	err := structValidator().Struct(pe)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqPatronEnable.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (pe *PatronEnable) GetSeqNum() int {
+	return pe.SeqNum
+}
+
+func (pe *PatronEnable) SetSeqNum(seqNum int) {
+	pe.SeqNum = seqNum
+}
+
+func (pe *PatronEnable) LogValue() slog.Value {
+	return redactedValue(pe)
+}
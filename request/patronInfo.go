@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -136,10 +137,22 @@ func (pi *PatronInfo) Validate() error {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqPatronInfo.String(), err)
 	}
 
-	err = Validate.Struct(pi)
+	err = structValidator().Struct(pi)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqPatronInfo.String(), err.(validator.ValidationErrors))
 	}
 
 	return nil
 }
+
+func (pi *PatronInfo) GetSeqNum() int {
+	return pi.SeqNum
+}
+
+func (pi *PatronInfo) SetSeqNum(seqNum int) {
+	pi.SeqNum = seqNum
+}
+
+func (pi *PatronInfo) LogValue() slog.Value {
+	return redactedValue(pi)
+}
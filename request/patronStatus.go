@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -94,9 +95,21 @@ func (ps *PatronStatus) Unmarshal(line string, delimiter, terminator rune) error
 }
 
 func (ps *PatronStatus) Validate() error {
-	err := Validate.Struct(ps)
+	err := structValidator().Struct(ps)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqPatronStatus.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (ps *PatronStatus) GetSeqNum() int {
+	return ps.SeqNum
+}
+
+func (ps *PatronStatus) SetSeqNum(seqNum int) {
+	ps.SeqNum = seqNum
+}
+
+func (ps *PatronStatus) LogValue() slog.Value {
+	return redactedValue(ps)
+}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -137,7 +138,7 @@ func (rn *Renew) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (rn *Renew) Validate() error {
-	err := Validate.Struct(rn)
+	err := structValidator().Struct(rn)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqRenew.String(), err.(validator.ValidationErrors))
 	}
@@ -148,3 +149,15 @@ func (rn *Renew) Validate() error {
 
 	return nil
 }
+
+func (rn *Renew) GetSeqNum() int {
+	return rn.SeqNum
+}
+
+func (rn *Renew) SetSeqNum(seqNum int) {
+	rn.SeqNum = seqNum
+}
+
+func (rn *Renew) LogValue() slog.Value {
+	return redactedValue(rn)
+}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -104,9 +105,21 @@ func (ra *RenewAll) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (ra *RenewAll) Validate() error {
-	err := Validate.Struct(ra)
+	err := structValidator().Struct(ra)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqRenewAll.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (ra *RenewAll) GetSeqNum() int {
+	return ra.SeqNum
+}
+
+func (ra *RenewAll) SetSeqNum(seqNum int) {
+	ra.SeqNum = seqNum
+}
+
+func (ra *RenewAll) LogValue() slog.Value {
+	return redactedValue(ra)
+}
@@ -2,6 +2,9 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
+	"reflect"
+	"sync/atomic"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/pescew/sip/types"
@@ -9,8 +12,6 @@ import (
 )
 
 var (
-	Validate *validator.Validate
-
 	ErrInvalidRequest = fmt.Errorf("Invalid SIP request")
 	ErrUnknownRequest = fmt.Errorf("Unknown SIP request")
 )
@@ -19,9 +20,14 @@ type Request interface {
 	Marshal(delimiter, terminator rune, errorDetection bool) string
 	Unmarshal(line string, delimiter, terminator rune) error
 	Validate() error
+	GetSeqNum() int
+	SetSeqNum(seqNum int)
 }
 
 func Unmarshal(line string, delimiter, terminator rune) (req Request, msgID string, err error) {
+	if len(line) < 2 {
+		return nil, "", ErrInvalidRequest
+	}
 	msgID = line[0:2]
 
 	switch msgID {
@@ -69,12 +75,60 @@ func Unmarshal(line string, delimiter, terminator rune) (req Request, msgID stri
 	return req, msgID, nil
 }
 
+// UnmarshalVerified checks the AZ checksum and AY sequence number of line before parsing it. If either is present but invalid the line is not parsed and the error is utils.ErrChecksumMismatch or utils.ErrInvalidSeqNum.
+func UnmarshalVerified(line string, delimiter, terminator rune) (req Request, msgID string, ed utils.ErrorDetection, err error) {
+	ed = utils.CheckErrorDetection(line)
+	err = ed.Err()
+	if err != nil {
+		return nil, "", ed, err
+	}
+
+	req, msgID, err = Unmarshal(line, delimiter, terminator)
+	return req, msgID, ed, err
+}
+
+// sipValidator is a validator whose "sip" fields may not contain the characters in excluded.
+type sipValidator struct {
+	validate *validator.Validate
+	excluded string
+}
+
+var currentValidator atomic.Pointer[sipValidator]
+
+// InitValidator sets up the validator used by the Validate methods of requests to refuse excludeChars in fields tagged "sip". It does nothing if the validator in use already excludes the same characters, and otherwise replaces it atomically, so it is safe to call while other goroutines are validating requests.
 func InitValidator(excludeChars ...rune) {
-	badChars := ""
-	for _, char := range excludeChars {
-		badChars += string(char)
+	badChars := string(excludeChars)
+	if current := currentValidator.Load(); current != nil && current.excluded == badChars {
+		return
+	}
+
+	validate := validator.New()
+	validate.RegisterValidation("sip", utils.GenerateSIPValidatorFunc(badChars))
+	currentValidator.Store(&sipValidator{validate: validate, excluded: badChars})
+}
+
+// structValidator returns the validator set up by InitValidator.
+func structValidator() *validator.Validate {
+	return currentValidator.Load().validate
+}
+
+// redactedValue returns the log value of req, a pointer to a request struct, with every non-empty field named in utils.SensitiveFields replaced by utils.RedactedValue.
+func redactedValue(req any) slog.Value {
+	v := reflect.ValueOf(req).Elem()
+	redacted := reflect.New(v.Type()).Elem()
+	redacted.Set(v)
+
+	sensitive := make(map[string]bool, len(utils.SensitiveFields))
+	for _, name := range utils.SensitiveFields {
+		sensitive[name] = true
+	}
+
+	for i := 0; i < redacted.NumField(); i++ {
+		field := redacted.Field(i)
+		if field.Kind() == reflect.String && field.String() != "" && sensitive[redacted.Type().Field(i).Name] {
+			field.SetString(utils.RedactedValue)
+		}
 	}
 
-	Validate = validator.New()
-	Validate.RegisterValidation("sip", utils.GenerateSIPValidatorFunc(badChars))
+	return slog.AnyValue(redacted.Interface())
 }
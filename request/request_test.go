@@ -0,0 +1,113 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pescew/sip/utils"
+)
+
+func TestUnmarshalVerified(t *testing.T) {
+	delimiter := '|'
+	terminator := '\r'
+
+	InitValidator(delimiter, terminator)
+	utils.ConfigureEscapeCharacters(delimiter, terminator)
+
+	req := &SCLogin{
+		LoginUserID:   "kiosk",
+		LoginPassword: "secret",
+		SeqNum:        4,
+	}
+
+	line := strings.TrimSuffix(req.Marshal(delimiter, terminator, true), string(terminator))
+
+	_, _, ed, err := UnmarshalVerified(line, delimiter, terminator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ed.ChecksumPresent || !ed.ChecksumValid || !ed.SeqNumPresent || !ed.SeqNumValid || ed.SeqNum != 4 {
+		t.Fatalf("unexpected error detection result: %+v", ed)
+	}
+
+	corrupted := strings.Replace(line, "kiosk", "kiosc", 1)
+	_, _, ed, err = UnmarshalVerified(corrupted, delimiter, terminator)
+	if !errors.Is(err, utils.ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	if !ed.ChecksumPresent || ed.ChecksumValid {
+		t.Fatalf("unexpected error detection result: %+v", ed)
+	}
+
+	plain := strings.TrimSuffix(req.Marshal(delimiter, terminator, false), string(terminator))
+	_, _, ed, err = UnmarshalVerified(plain, delimiter, terminator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ed.ChecksumPresent || ed.SeqNumPresent {
+		t.Fatalf("unexpected error detection result: %+v", ed)
+	}
+}
+
+func TestLogValueRedactsPasswords(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	logger.Info("request",
+		"login", &SCLogin{LoginUserID: "kiosk", LoginPassword: "terminal-secret"},
+		"checkout", &Checkout{PatronID: "johndoe", TerminalPassword: "terminal-secret", PatronPassword: "patron-pin"},
+	)
+
+	out := buf.String()
+	if strings.Contains(out, "terminal-secret") || strings.Contains(out, "patron-pin") {
+		t.Fatalf("password logged: %s", out)
+	}
+	if !strings.Contains(out, "kiosk") || !strings.Contains(out, "johndoe") || !strings.Contains(out, utils.RedactedValue) {
+		t.Fatalf("unexpected log output: %s", out)
+	}
+
+	line := (&SCLogin{LoginUserID: "kiosk", LoginPassword: "terminal-secret", LocationCode: "desk"}).Marshal('|', '\r', true)
+	redacted := utils.RedactFields(line, '|')
+	if strings.Contains(redacted, "terminal-secret") || !strings.Contains(redacted, "CO"+utils.RedactedValue+"|") || !strings.Contains(redacted, "CPdesk|") {
+		t.Fatalf("unexpected redacted line: %q", redacted)
+	}
+}
+
+func TestSensitiveFieldsRedacted(t *testing.T) {
+	for _, req := range []Request{
+		&BlockPatron{}, &Checkin{}, &Checkout{}, &EndPatronSession{}, &FeePaid{}, &Hold{}, &ItemInfo{},
+		&ItemStatusUpdate{}, &PatronEnable{}, &PatronInfo{}, &PatronStatus{}, &Renew{}, &RenewAll{}, &SCLogin{},
+	} {
+		v := reflect.ValueOf(req).Elem()
+		for code, name := range utils.SensitiveFields {
+			field := v.FieldByName(name)
+			if field.IsValid() {
+				field.SetString("secret-" + code)
+			}
+		}
+
+		// Every field logged as a password must also be masked in the raw line under its code, and the other way round.
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Name
+			if v.Field(i).Kind() != reflect.String || !strings.HasSuffix(name, "Password") {
+				continue
+			}
+			if !strings.HasPrefix(v.Field(i).String(), "secret-") {
+				t.Errorf("%T: %s is not in utils.SensitiveFields", req, name)
+			}
+		}
+
+		line := utils.RedactFields(req.Marshal('|', '\r', false), '|')
+		logged := req.(slog.LogValuer).LogValue().String()
+		if strings.Contains(line, "secret-") || strings.Contains(logged, "secret-") {
+			t.Errorf("%T: password not masked: %q %s", req, line, logged)
+		}
+	}
+}
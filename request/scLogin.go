@@ -2,6 +2,7 @@ package request
 
 import (
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 
@@ -92,9 +93,21 @@ func (scl *SCLogin) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (scl *SCLogin) Validate() error {
-	err := Validate.Struct(scl)
+	err := structValidator().Struct(scl)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqSCLogin.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (scl *SCLogin) GetSeqNum() int {
+	return scl.SeqNum
+}
+
+func (scl *SCLogin) SetSeqNum(seqNum int) {
+	scl.SeqNum = seqNum
+}
+
+func (scl *SCLogin) LogValue() slog.Value {
+	return redactedValue(scl)
+}
@@ -50,9 +50,15 @@ func (scs *SCStatus) Unmarshal(line string, delimiter, terminator rune) error {
 		return ErrInvalidRequest99
 	}
 
-	scs.SeqNum, err = strconv.Atoi(string(runes[12]))
-	if err != nil {
+	codes := utils.ExtractFields(string(runes[10:]), delimiter, map[string]string{"AY": ""})
+	seqNumString := codes["AY"]
+	if seqNumString == "" {
 		scs.SeqNum = 0
+	} else {
+		scs.SeqNum, err = strconv.Atoi(seqNumString)
+		if err != nil {
+			scs.SeqNum = 0
+		}
 	}
 
 	scs.StatusCode, err = strconv.Atoi(string(runes[2]))
@@ -74,9 +80,17 @@ func (scs *SCStatus) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (scs *SCStatus) Validate() error {
-	err := Validate.Struct(scs)
+	err := structValidator().Struct(scs)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.ReqSCStatus.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (scs *SCStatus) GetSeqNum() int {
+	return scs.SeqNum
+}
+
+func (scs *SCStatus) SetSeqNum(seqNum int) {
+	scs.SeqNum = seqNum
+}
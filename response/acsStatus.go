@@ -166,9 +166,17 @@ func (st *ACSStatus) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (st *ACSStatus) Validate() error {
-	err := Validate.Struct(st)
+	err := structValidator().Struct(st)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespACSStatus.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (st *ACSStatus) GetSeqNum() int {
+	return st.SeqNum
+}
+
+func (st *ACSStatus) SetSeqNum(seqNum int) {
+	st.SeqNum = seqNum
+}
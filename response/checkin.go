@@ -149,9 +149,17 @@ func (ci *Checkin) Validate() error {
 		return fmt.Errorf("invalid SIP %s did not pass validation: MediaType must be 3 chars", types.RespCheckin.String())
 	}
 
-	err := Validate.Struct(ci)
+	err := structValidator().Struct(ci)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespCheckin.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (ci *Checkin) GetSeqNum() int {
+	return ci.SeqNum
+}
+
+func (ci *Checkin) SetSeqNum(seqNum int) {
+	ci.SeqNum = seqNum
+}
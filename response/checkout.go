@@ -181,9 +181,17 @@ func (co *Checkout) Validate() error {
 		return fmt.Errorf("invalid SIP %s did not pass validation: MediaType must be 3 chars", types.RespCheckout.String())
 	}
 
-	err := Validate.Struct(co)
+	err := structValidator().Struct(co)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespCheckout.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (co *Checkout) GetSeqNum() int {
+	return co.SeqNum
+}
+
+func (co *Checkout) SetSeqNum(seqNum int) {
+	co.SeqNum = seqNum
+}
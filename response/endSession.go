@@ -98,9 +98,17 @@ func (es *EndSession) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (es *EndSession) Validate() error {
-	err := Validate.Struct(es)
+	err := structValidator().Struct(es)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespEndSession.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (es *EndSession) GetSeqNum() int {
+	return es.SeqNum
+}
+
+func (es *EndSession) SetSeqNum(seqNum int) {
+	es.SeqNum = seqNum
+}
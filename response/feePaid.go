@@ -104,9 +104,17 @@ func (fp *FeePaid) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (fp *FeePaid) Validate() error {
-	err := Validate.Struct(fp)
+	err := structValidator().Struct(fp)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespFeePaid.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (fp *FeePaid) GetSeqNum() int {
+	return fp.SeqNum
+}
+
+func (fp *FeePaid) SetSeqNum(seqNum int) {
+	fp.SeqNum = seqNum
+}
@@ -157,9 +157,17 @@ func (h *Hold) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (h *Hold) Validate() error {
-	err := Validate.Struct(h)
+	err := structValidator().Struct(h)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespHold.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (h *Hold) GetSeqNum() int {
+	return h.SeqNum
+}
+
+func (h *Hold) SetSeqNum(seqNum int) {
+	h.SeqNum = seqNum
+}
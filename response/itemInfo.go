@@ -224,9 +224,17 @@ func (ii *ItemInfo) Validate() error {
 		return fmt.Errorf("invalid SIP %s did not pass validation: MediaType must be 3 chars", types.RespItemInfo.String())
 	}
 
-	err := Validate.Struct(ii)
+	err := structValidator().Struct(ii)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespItemInfo.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (ii *ItemInfo) GetSeqNum() int {
+	return ii.SeqNum
+}
+
+func (ii *ItemInfo) SetSeqNum(seqNum int) {
+	ii.SeqNum = seqNum
+}
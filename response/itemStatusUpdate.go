@@ -108,9 +108,17 @@ func (isu *ItemStatusUpdate) Unmarshal(line string, delimiter, terminator rune)
 }
 
 func (isu *ItemStatusUpdate) Validate() error {
-	err := Validate.Struct(isu)
+	err := structValidator().Struct(isu)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespItemStatusUpdate.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (isu *ItemStatusUpdate) GetSeqNum() int {
+	return isu.SeqNum
+}
+
+func (isu *ItemStatusUpdate) SetSeqNum(seqNum int) {
+	isu.SeqNum = seqNum
+}
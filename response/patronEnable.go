@@ -126,9 +126,17 @@ func (pe *PatronEnable) Unmarshal(line string, delimiter, terminator rune) error
 }
 
 func (pe *PatronEnable) Validate() error {
-	err := Validate.Struct(pe)
+	err := structValidator().Struct(pe)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespPatronEnable.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (pe *PatronEnable) GetSeqNum() int {
+	return pe.SeqNum
+}
+
+func (pe *PatronEnable) SetSeqNum(seqNum int) {
+	pe.SeqNum = seqNum
+}
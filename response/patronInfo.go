@@ -298,9 +298,17 @@ func (pi *PatronInfo) Validate() error {
 		return fmt.Errorf("invalid SIP %s did not pass validation: CurrencyType must be 3 chars", types.RespPatronInfo.String())
 	}
 
-	err := Validate.Struct(pi)
+	err := structValidator().Struct(pi)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespPatronInfo.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (pi *PatronInfo) GetSeqNum() int {
+	return pi.SeqNum
+}
+
+func (pi *PatronInfo) SetSeqNum(seqNum int) {
+	pi.SeqNum = seqNum
+}
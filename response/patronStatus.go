@@ -146,9 +146,17 @@ func (ps *PatronStatus) Validate() error {
 		return fmt.Errorf("invalid SIP %s did not pass validation: CurrencyType must be 3 chars", types.RespPatronStatus.String())
 	}
 
-	err := Validate.Struct(ps)
+	err := structValidator().Struct(ps)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespPatronStatus.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (ps *PatronStatus) GetSeqNum() int {
+	return ps.SeqNum
+}
+
+func (ps *PatronStatus) SetSeqNum(seqNum int) {
+	ps.SeqNum = seqNum
+}
@@ -181,9 +181,17 @@ func (rn *Renew) Validate() error {
 		return fmt.Errorf("invalid SIP %s did not pass validation: MediaType must be 3 chars", types.RespRenew.String())
 	}
 
-	err := Validate.Struct(rn)
+	err := structValidator().Struct(rn)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespRenew.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (rn *Renew) GetSeqNum() int {
+	return rn.SeqNum
+}
+
+func (rn *Renew) SetSeqNum(seqNum int) {
+	rn.SeqNum = seqNum
+}
@@ -2,6 +2,7 @@ package response
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/pescew/sip/types"
@@ -9,8 +10,6 @@ import (
 )
 
 var (
-	Validate *validator.Validate
-
 	ErrInvalidResponse = fmt.Errorf("Invalid SIP response")
 	ErrUnknownResponse = fmt.Errorf("Unknown SIP response")
 )
@@ -19,9 +18,14 @@ type Response interface {
 	Marshal(delimiter, terminator rune, errorDetection bool) string
 	Unmarshal(line string, delimiter, terminator rune) error
 	Validate() error
+	GetSeqNum() int
+	SetSeqNum(seqNum int)
 }
 
 func Unmarshal(line string, delimiter, terminator rune) (resp Response, msgID string, err error) {
+	if len(line) < 2 {
+		return nil, "", ErrInvalidResponse
+	}
 	msgID = line[0:2]
 
 	switch msgID {
@@ -67,12 +71,39 @@ func Unmarshal(line string, delimiter, terminator rune) (resp Response, msgID st
 	return resp, msgID, nil
 }
 
+// UnmarshalVerified checks the AZ checksum and AY sequence number of line before parsing it. If either is present but invalid the line is not parsed and the error is utils.ErrChecksumMismatch or utils.ErrInvalidSeqNum.
+func UnmarshalVerified(line string, delimiter, terminator rune) (resp Response, msgID string, ed utils.ErrorDetection, err error) {
+	ed = utils.CheckErrorDetection(line)
+	err = ed.Err()
+	if err != nil {
+		return nil, "", ed, err
+	}
+
+	resp, msgID, err = Unmarshal(line, delimiter, terminator)
+	return resp, msgID, ed, err
+}
+
+// sipValidator is a validator whose "sip" fields may not contain the characters in excluded.
+type sipValidator struct {
+	validate *validator.Validate
+	excluded string
+}
+
+var currentValidator atomic.Pointer[sipValidator]
+
+// InitValidator sets up the validator used by the Validate methods of responses to refuse excludeChars in fields tagged "sip". It does nothing if the validator in use already excludes the same characters, and otherwise replaces it atomically, so it is safe to call while other goroutines are validating responses.
 func InitValidator(excludeChars ...rune) {
-	badChars := ""
-	for _, char := range excludeChars {
-		badChars += string(char)
+	badChars := string(excludeChars)
+	if current := currentValidator.Load(); current != nil && current.excluded == badChars {
+		return
 	}
 
-	Validate = validator.New()
-	Validate.RegisterValidation("sip", utils.GenerateSIPValidatorFunc(badChars))
+	validate := validator.New()
+	validate.RegisterValidation("sip", utils.GenerateSIPValidatorFunc(badChars))
+	currentValidator.Store(&sipValidator{validate: validate, excluded: badChars})
+}
+
+// structValidator returns the validator set up by InitValidator.
+func structValidator() *validator.Validate {
+	return currentValidator.Load().validate
 }
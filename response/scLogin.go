@@ -71,9 +71,17 @@ func (scl *SCLogin) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (scl *SCLogin) Validate() error {
-	err := Validate.Struct(scl)
+	err := structValidator().Struct(scl)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespSCLogin.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (scl *SCLogin) GetSeqNum() int {
+	return scl.SeqNum
+}
+
+func (scl *SCLogin) SetSeqNum(seqNum int) {
+	scl.SeqNum = seqNum
+}
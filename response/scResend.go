@@ -40,9 +40,15 @@ func (scr *SCResend) Unmarshal(line string, delimiter, terminator rune) error {
 }
 
 func (scr *SCResend) Validate() error {
-	err := Validate.Struct(scr)
+	err := structValidator().Struct(scr)
 	if err != nil {
 		return fmt.Errorf("invalid SIP %s did not pass validation: %v", types.RespSCResend.String(), err.(validator.ValidationErrors))
 	}
 	return nil
 }
+
+func (scr *SCResend) GetSeqNum() int {
+	return 0
+}
+
+func (scr *SCResend) SetSeqNum(seqNum int) {}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pescew/sip/request"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidCredentials = fmt.Errorf("invalid SIP terminal credentials")
+
+// Account is a terminal account an SC can log in with. PasswordHash is a bcrypt hash of the terminal password, see HashPassword. InstitutionID and LocationCode describe the terminal and are made available through the Session once it has logged in. If AllowedNetworks is not empty, logins are only accepted from source IPs within it. Connections over a Unix socket have no source IP and are not checked against AllowedNetworks, the same as for Config.AllowedNetworks.
+type Account struct {
+	LoginUserID     string
+	PasswordHash    string
+	InstitutionID   string
+	LocationCode    string
+	AllowedNetworks []netip.Prefix
+}
+
+// Allows reports whether the account may log in from ip. The zero Addr, the source IP of a connection that is not over IP, is always allowed.
+func (a *Account) Allows(ip netip.Addr) bool {
+	return len(a.AllowedNetworks) == 0 || !ip.IsValid() || networksContain(a.AllowedNetworks, ip)
+}
+
+// networksContain reports whether ip is within any of networks.
+func networksContain(networks []netip.Prefix, ip netip.Addr) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNetworks returns an error for the first invalid prefix in networks.
+func validateNetworks(networks []netip.Prefix) error {
+	for i, network := range networks {
+		if !network.IsValid() {
+			return fmt.Errorf("invalid network at index %d", i)
+		}
+	}
+	return nil
+}
+
+// Authenticator decides SC Login requests. Authenticate returns the account the SC logged in as, or ErrInvalidCredentials if the login is refused. Any other error is logged and also refuses the login.
+type Authenticator interface {
+	Authenticate(ctx context.Context, r *request.SCLogin) (*Account, error)
+}
+
+// AccountLookup is implemented by Authenticators that can find an account without its password, such as AccountTable. It is used to resolve the terminal identity of a TLS client certificate to an account.
+type AccountLookup interface {
+	LookupAccount(loginUserID string) (*Account, bool)
+}
+
+// HashPassword returns the bcrypt hash of a terminal password for use in Account.PasswordHash.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches hash, a hash made by HashPassword.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// AccountTable is an Authenticator backed by an in-memory table of accounts. Login user IDs are matched case-insensitively. It is safe for concurrent use, so accounts can be added or revoked while the server is running.
+type AccountTable struct {
+	mu       sync.RWMutex
+	accounts map[string]Account
+}
+
+func NewAccountTable(accounts ...Account) (*AccountTable, error) {
+	table := &AccountTable{
+		accounts: make(map[string]Account),
+	}
+
+	for _, account := range accounts {
+		err := table.Set(account)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
+
+// LoadAccounts reads an AccountTable from a JSON file holding a list of accounts, for example:
+//
+//	[{"login_user_id": "kiosk-01", "password_hash": "$2a$10$...", "institution_id": "main", "location_code": "lobby", "allowed_networks": ["10.1.0.0/16"]}]
+func LoadAccounts(path string) (*AccountTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		LoginUserID     string         `json:"login_user_id"`
+		PasswordHash    string         `json:"password_hash"`
+		InstitutionID   string         `json:"institution_id"`
+		LocationCode    string         `json:"location_code"`
+		AllowedNetworks []netip.Prefix `json:"allowed_networks"`
+	}
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("error reading accounts file %s: %v", path, err)
+	}
+
+	accounts := make([]Account, 0, len(entries))
+	for _, entry := range entries {
+		accounts = append(accounts, Account(entry))
+	}
+	return NewAccountTable(accounts...)
+}
+
+// Set adds account to the table, replacing any account with the same login user ID.
+func (at *AccountTable) Set(account Account) error {
+	if account.LoginUserID == "" {
+		return fmt.Errorf("invalid account - login user ID is required")
+	}
+
+	_, err := bcrypt.Cost([]byte(account.PasswordHash))
+	if err != nil {
+		return fmt.Errorf("invalid password hash for account %s: %v", account.LoginUserID, err)
+	}
+
+	err = validateNetworks(account.AllowedNetworks)
+	if err != nil {
+		return fmt.Errorf("invalid allowed networks for account %s: %v", account.LoginUserID, err)
+	}
+
+	at.mu.Lock()
+	at.accounts[strings.ToLower(account.LoginUserID)] = account
+	at.mu.Unlock()
+	return nil
+}
+
+// Revoke removes the account with loginUserID. Sessions that are already logged in are not affected.
+func (at *AccountTable) Revoke(loginUserID string) {
+	at.mu.Lock()
+	delete(at.accounts, strings.ToLower(loginUserID))
+	at.mu.Unlock()
+}
+
+// LookupAccount returns the account with loginUserID, if it has not been revoked.
+func (at *AccountTable) LookupAccount(loginUserID string) (*Account, bool) {
+	at.mu.RLock()
+	defer at.mu.RUnlock()
+
+	account, ok := at.accounts[strings.ToLower(loginUserID)]
+	if !ok {
+		return nil, false
+	}
+	return &account, true
+}
+
+// Accounts returns a copy of every account in the table.
+func (at *AccountTable) Accounts() []Account {
+	at.mu.RLock()
+	defer at.mu.RUnlock()
+
+	accounts := make([]Account, 0, len(at.accounts))
+	for _, account := range at.accounts {
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+// dummyHash is compared against when the login user ID is unknown, so that unknown and known users take the same time to refuse.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("unknown terminal"), bcrypt.DefaultCost)
+	return hash
+})
+
+func (at *AccountTable) Authenticate(ctx context.Context, r *request.SCLogin) (*Account, error) {
+	at.mu.RLock()
+	account, ok := at.accounts[strings.ToLower(r.LoginUserID)]
+	at.mu.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(r.LoginPassword))
+		return nil, ErrInvalidCredentials
+	}
+
+	if !CheckPassword(account.PasswordHash, r.LoginPassword) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &account, nil
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+func TestAccountTable(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := NewAccountTable(
+		Account{LoginUserID: "kiosk-01", PasswordHash: hash, InstitutionID: "north", LocationCode: "north-lobby"},
+		Account{LoginUserID: "kiosk-02", PasswordHash: hash, InstitutionID: "south"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.RequireLogin = true
+	cfg.Authenticator = accounts
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		session := SessionFromContext(ctx)
+		account, _ := session.Account()
+		return &response.PatronInfo{
+			TransactionDate: time.Now(),
+			InstitutionID:   account.InstitutionID,
+			PatronID:        r.PatronID,
+			PatronName:      session.LocationCode(),
+		}, nil
+	})
+
+	addr := serveTest(t, srv)
+
+	for _, tc := range []struct {
+		login         request.SCLogin
+		ok            bool
+		institutionID string
+		location      string
+	}{
+		{request.SCLogin{LoginUserID: "KIOSK-01", LoginPassword: "secret", LocationCode: "ignored"}, true, "north", "north-lobby"},
+		{request.SCLogin{LoginUserID: "kiosk-02", LoginPassword: "secret", LocationCode: "south-desk"}, true, "south", "south-desk"},
+		{request.SCLogin{LoginUserID: "kiosk-02", LoginPassword: "wrong"}, false, "", ""},
+		{request.SCLogin{LoginUserID: "kiosk-03", LoginPassword: "secret"}, false, "", ""},
+	} {
+		c, err := client.Dial(addr, client.DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		login, err := c.Login(&tc.login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if login.Ok != tc.ok {
+			t.Fatalf("login %s: got Ok=%t", tc.login.LoginUserID, login.Ok)
+		}
+		if !tc.ok {
+			continue
+		}
+
+		info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.InstitutionID != tc.institutionID || info.PatronName != tc.location {
+			t.Fatalf("login %s: unexpected session account %s at %s", tc.login.LoginUserID, info.InstitutionID, info.PatronName)
+		}
+	}
+
+	accounts.Revoke("kiosk-01")
+
+	c, err := client.Dial(addr, client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	login, err := c.Login(&request.SCLogin{LoginUserID: "kiosk-01", LoginPassword: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.Ok {
+		t.Fatalf("revoked account was able to log in")
+	}
+}
+
+func TestSCLoginHandlerWithAuthenticator(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Authenticator, err = NewAccountTable(
+		Account{LoginUserID: "kiosk-01", PasswordHash: hash, InstitutionID: "north"},
+		Account{LoginUserID: "kiosk-02", PasswordHash: hash, InstitutionID: "south"},
+		Account{LoginUserID: "kiosk-03", PasswordHash: hash, AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var called []string
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		called = append(called, r.LoginUserID)
+		account, ok := AccountFromContext(ctx)
+		return &response.SCLogin{Ok: ok && account.InstitutionID == "north"}, nil
+	})
+
+	c, err := client.Dial(serveTest(t, srv), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The Authenticator decides first, and the handler only sees the logins it accepted.
+	for _, tc := range []struct {
+		login request.SCLogin
+		ok    bool
+	}{
+		{request.SCLogin{LoginUserID: "kiosk-01", LoginPassword: "wrong"}, false},
+		{request.SCLogin{LoginUserID: "kiosk-04", LoginPassword: "secret"}, false},
+		{request.SCLogin{LoginUserID: "kiosk-03", LoginPassword: "secret"}, false},
+		{request.SCLogin{LoginUserID: "kiosk-02", LoginPassword: "secret"}, false},
+		{request.SCLogin{LoginUserID: "kiosk-01", LoginPassword: "secret"}, true},
+	} {
+		login, err := c.Login(&tc.login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if login.Ok != tc.ok {
+			t.Fatalf("login %s: got Ok=%t", tc.login.LoginUserID, login.Ok)
+		}
+	}
+
+	if strings.Join(called, ",") != "kiosk-02,kiosk-01" {
+		t.Fatalf("SC Login handler called for %v", called)
+	}
+}
+
+func TestAllowedNetworks(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := NewAccountTable(
+		Account{LoginUserID: "local", PasswordHash: hash, AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}},
+		Account{LoginUserID: "branch", PasswordHash: hash, AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.20.0.0/16")}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Authenticator = accounts
+	cfg.AllowedNetworks = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("127.0.0.1/32")}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTest(t, srv)
+
+	for _, tc := range []struct {
+		loginUserID string
+		ok          bool
+	}{
+		{"local", true},
+		{"branch", false},
+	} {
+		c, err := client.Dial(addr, client.DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		login, err := c.Login(&request.SCLogin{LoginUserID: tc.loginUserID, LoginPassword: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if login.Ok != tc.ok {
+			t.Fatalf("login %s: got Ok=%t", tc.loginUserID, login.Ok)
+		}
+	}
+
+	cfg.AllowedNetworks = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	srv, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.Dial(serveTest(t, srv), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.Login(&request.SCLogin{LoginUserID: "local", LoginPassword: "secret"})
+	if err == nil {
+		t.Fatalf("connection from outside the allowed networks was served")
+	}
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigKeys are the keys recognized in config files loaded by LoadConfig. Each can also be set with an environment variable named SIP_ followed by the key in upper case, for example SIP_INSTITUTION_ID.
+var ConfigKeys = []string{
+	"host",
+	"port",
+	"unix_socket",
+	"socket_activation",
+	"telnet",
+	"debug_mode",
+	"library_id",
+	"institution_id",
+	"terminal_username",
+	"terminal_password",
+	"terminator_character",
+	"delimiter_character",
+	"error_detection",
+	"require_error_detection",
+	"connection_timeout",
+	"write_timeout",
+	"max_lifetime",
+	"allowed_networks",
+	"require_login",
+	"accounts_file",
+	"tls_cert_file",
+	"tls_key_file",
+	"tls_client_ca_file",
+	"tls_client_cert_login",
+	"send_error_responses",
+	"error_screen_message",
+	"max_connections",
+	"max_connections_per_ip",
+	"message_rate",
+	"message_burst",
+	"limit_action",
+	"metrics_addr",
+	"websocket_addr",
+	"websocket_origins",
+	"status_timeout_period",
+	"status_retries_allowed",
+	"status_offline_ok",
+}
+
+// configListKeys are the keys that hold a list of values. In environment variables the values are separated by commas.
+var configListKeys = []string{"allowed_networks", "websocket_origins"}
+
+// configValue is a raw value read from a config file or environment variable.
+type configValue struct {
+	values []string
+	list   bool
+}
+
+// LoadConfig returns DefaultConfig updated from the config file at path, then from SIP_* environment variables, and checked with Validate. If path is empty only the environment is used.
+//
+// The file format is chosen by extension: .json, .yaml or .yml, or .toml. Files are a flat set of ConfigKeys, with lists for allowed_networks and websocket_origins; nested values are refused. The terminator and delimiter characters are written as quoted strings with escapes, such as "\r" and "|". accounts_file loads an AccountTable with LoadAccounts to authenticate SC Login requests. TLS is enabled by tls_cert_file and tls_key_file, and tls_client_ca_file requires clients to present a certificate issued by one of its CAs.
+func LoadConfig(path string) (Config, error) {
+	values := make(map[string]configValue)
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json":
+			values, err = parseConfigFile(data, json.Unmarshal)
+		case ".yaml", ".yml":
+			values, err = parseConfigFile(data, yaml.Unmarshal)
+		case ".toml":
+			values, err = parseConfigFile(data, toml.Unmarshal)
+		default:
+			err = fmt.Errorf("unsupported config file format: %s", filepath.Ext(path))
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("error reading config file %s: %v", path, err)
+		}
+	}
+
+	for key := range values {
+		if !slices.Contains(ConfigKeys, key) {
+			return Config{}, fmt.Errorf("unknown config key: %s", key)
+		}
+	}
+
+	for _, key := range ConfigKeys {
+		env, ok := os.LookupEnv("SIP_" + strings.ToUpper(key))
+		if !ok {
+			continue
+		}
+		if slices.Contains(configListKeys, key) {
+			values[key] = configValue{values: splitList(env), list: true}
+		} else {
+			values[key] = configValue{values: []string{env}}
+		}
+	}
+
+	cfg := DefaultConfig()
+	var files tlsFiles
+	for _, key := range ConfigKeys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		err := setConfigValue(&cfg, &files, key, value)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid config value for %s: %v", key, err)
+		}
+	}
+	if files.certFile != "" || files.keyFile != "" || files.clientCAFile != "" {
+		tlsConfig, err := files.load()
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.TLSConfig = tlsConfig
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// tlsFiles are the TLS file paths read by LoadConfig.
+type tlsFiles struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+}
+
+func (tf tlsFiles) load() (*tls.Config, error) {
+	if tf.certFile == "" || tf.keyFile == "" {
+		return nil, fmt.Errorf("TLS requires both tls_cert_file and tls_key_file")
+	}
+
+	cert, err := tls.LoadX509KeyPair(tf.certFile, tf.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if tf.clientCAFile != "" {
+		pem, err := os.ReadFile(tf.clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS client CAs: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", tf.clientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+func setConfigValue(cfg *Config, files *tlsFiles, key string, value configValue) error {
+	if slices.Contains(configListKeys, key) {
+		switch key {
+		case "allowed_networks":
+			networks := make([]netip.Prefix, 0, len(value.values))
+			for _, v := range value.values {
+				network, err := netip.ParsePrefix(v)
+				if err != nil {
+					return err
+				}
+				networks = append(networks, network)
+			}
+			cfg.AllowedNetworks = networks
+		case "websocket_origins":
+			cfg.WebSocketOrigins = slices.Clone(value.values)
+		}
+		return nil
+	}
+
+	if value.list || len(value.values) != 1 {
+		return fmt.Errorf("expected a single value")
+	}
+	v := value.values[0]
+
+	var err error
+	switch key {
+	case "host":
+		cfg.Host = v
+	case "port":
+		cfg.Port, err = strconv.Atoi(v)
+	case "unix_socket":
+		cfg.UnixSocket = v
+	case "socket_activation":
+		cfg.SocketActivation, err = strconv.ParseBool(v)
+	case "telnet":
+		cfg.Telnet, err = strconv.ParseBool(v)
+	case "debug_mode":
+		cfg.DebugMode, err = strconv.ParseBool(v)
+	case "library_id":
+		cfg.LibraryID = v
+	case "institution_id":
+		cfg.InstitutionID = v
+	case "terminal_username":
+		cfg.TerminalUsername = v
+	case "terminal_password":
+		cfg.TerminalPassword = v
+	case "terminator_character":
+		cfg.TerminatorCharacter, err = parseRune(v)
+	case "delimiter_character":
+		cfg.DelimiterCharacter, err = parseRune(v)
+	case "error_detection":
+		cfg.ErrorDetection, err = strconv.ParseBool(v)
+	case "require_error_detection":
+		cfg.RequireErrorDetection, err = strconv.ParseBool(v)
+	case "connection_timeout":
+		cfg.ConnectionTimeout, err = strconv.Atoi(v)
+	case "write_timeout":
+		cfg.WriteTimeout, err = strconv.Atoi(v)
+	case "max_lifetime":
+		cfg.MaxLifetime, err = strconv.Atoi(v)
+	case "require_login":
+		cfg.RequireLogin, err = strconv.ParseBool(v)
+	case "accounts_file":
+		cfg.Authenticator, err = LoadAccounts(v)
+	case "tls_cert_file":
+		files.certFile = v
+	case "tls_key_file":
+		files.keyFile = v
+	case "tls_client_ca_file":
+		files.clientCAFile = v
+	case "tls_client_cert_login":
+		cfg.TLSClientCertLogin, err = strconv.ParseBool(v)
+	case "send_error_responses":
+		cfg.SendErrorResponses, err = strconv.ParseBool(v)
+	case "error_screen_message":
+		cfg.ErrorScreenMessage = v
+	case "max_connections":
+		cfg.MaxConnections, err = strconv.Atoi(v)
+	case "max_connections_per_ip":
+		cfg.MaxConnectionsPerIP, err = strconv.Atoi(v)
+	case "message_rate":
+		cfg.MessageRate, err = strconv.ParseFloat(v, 64)
+	case "message_burst":
+		cfg.MessageBurst, err = strconv.Atoi(v)
+	case "limit_action":
+		cfg.LimitAction, err = ParseLimitAction(v)
+	case "metrics_addr":
+		cfg.MetricsAddr = v
+	case "websocket_addr":
+		cfg.WebSocketAddr = v
+	case "status_timeout_period":
+		cfg.StatusTimeoutPeriod, err = strconv.Atoi(v)
+	case "status_retries_allowed":
+		cfg.StatusRetriesAllowed, err = strconv.Atoi(v)
+	case "status_offline_ok":
+		cfg.StatusOfflineOK, err = strconv.ParseBool(v)
+	}
+	return err
+}
+
+// parseRune parses a character written either as itself or in escaped form, such as \r or \u001e.
+func parseRune(v string) (rune, error) {
+	if utf8.RuneCountInString(v) == 1 {
+		r, _ := utf8.DecodeRuneInString(v)
+		return r, nil
+	}
+
+	unquoted, err := strconv.Unquote(`"` + v + `"`)
+	if err != nil || utf8.RuneCountInString(unquoted) != 1 {
+		return 0, fmt.Errorf("expected a single character, got %q", v)
+	}
+	r, _ := utf8.DecodeRuneInString(unquoted)
+	return r, nil
+}
+
+func splitList(v string) []string {
+	var values []string
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// parseConfigFile decodes data with decode, which must fill a map with one entry per config key, and returns the values read.
+func parseConfigFile(data []byte, decode func([]byte, any) error) (map[string]configValue, error) {
+	var raw map[string]any
+	err := decode(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]configValue, len(raw))
+	for key, v := range raw {
+		switch v := v.(type) {
+		case nil:
+			continue
+		case []any:
+			list := configValue{values: make([]string, 0, len(v)), list: true}
+			for _, item := range v {
+				s, err := configScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", key, err)
+				}
+				list.values = append(list.values, s)
+			}
+			values[key] = list
+		default:
+			s, err := configScalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			values[key] = configValue{values: []string{s}}
+		}
+	}
+	return values, nil
+}
+
+// configScalar formats a single decoded value the way it is written in an environment variable. Nested values such as tables and mappings are not supported.
+func configScalar(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	files := map[string]string{
+		"sip.json": `{
+	"host": "0.0.0.0",
+	"port": 6001,
+	"institution_id": "main",
+	"terminator_character": "\r",
+	"delimiter_character": "|",
+	"allowed_networks": ["10.0.0.0/8", "127.0.0.1/32"],
+	"require_login": true,
+	"message_rate": 2.5,
+	"limit_action": "reject",
+	"error_screen_message": "See staff # at the desk"
+}`,
+		"sip.yaml": `# SIP server
+host: 0.0.0.0
+port: 6001
+institution_id: 'main'
+terminator_character: "\r"
+delimiter_character: "|"
+allowed_networks:
+  - 10.0.0.0/8
+  - "127.0.0.1/32"
+require_login: true
+message_rate: 2.5 # per second
+limit_action: reject
+error_screen_message: "See staff # at the desk"
+`,
+		"sip.toml": `# SIP server
+host = "0.0.0.0"
+port = 6001
+institution_id = 'main'
+terminator_character = "\r"
+delimiter_character = "|"
+allowed_networks = ["10.0.0.0/8", "127.0.0.1/32"]
+require_login = true
+message_rate = 2.5
+limit_action = "reject"
+error_screen_message = "See staff # at the desk"
+`,
+	}
+
+	expected := DefaultConfig()
+	expected.Host = "0.0.0.0"
+	expected.Port = 6001
+	expected.InstitutionID = "main"
+	expected.AllowedNetworks = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("127.0.0.1/32")}
+	expected.RequireLogin = true
+	expected.MessageRate = 2.5
+	expected.LimitAction = LimitReject
+	expected.ErrorScreenMessage = "See staff # at the desk"
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !reflect.DeepEqual(cfg, expected) {
+			t.Fatalf("%s: got %+v, expected %+v", name, cfg, expected)
+		}
+	}
+
+	t.Setenv("SIP_PORT", "6002")
+	t.Setenv("SIP_DELIMITER_CHARACTER", `\u005e`)
+	t.Setenv("SIP_ALLOWED_NETWORKS", "192.168.0.0/16, 172.16.0.0/12")
+
+	cfg, err := LoadConfig(filepath.Join(dir, "sip.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != 6002 || cfg.DelimiterCharacter != '^' || len(cfg.AllowedNetworks) != 2 || cfg.AllowedNetworks[1] != netip.MustParsePrefix("172.16.0.0/12") {
+		t.Fatalf("environment overrides not applied: %+v", cfg)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"unknown.json":      `{"institution": "main"}`,
+		"delimiter.yaml":    "delimiter_character: \"\\r\"\n",
+		"port.toml":         "port = 70000\n",
+		"rune.toml":         "terminator_character = \"ab\"\n",
+		"table.toml":        "[server]\nport = 6001\n",
+		"nested.yaml":       "server:\n  port: 6001\n",
+		"format.ini":        "port=6001\n",
+		"duplicate.yaml":    "port: 6001\nport: 6002\n",
+		"duplicate.toml":    "port = 6001\nport = 6002\n",
+		"flow.yaml":         "host: {ip: 0.0.0.0}\n",
+		"nestedlist.yaml":   "allowed_networks:\n  - [10.0.0.0/8]\n",
+		"unterminated.yaml": "institution_id: \"main\n",
+		"trailing.toml":     "institution_id = \"main\" extra\n",
+		"inline.toml":       "host = { ip = \"0.0.0.0\" }\n",
+		"array.toml":        "[[server]]\nport = 6001\n",
+		"date.toml":         "institution_id = 2024-01-01\n",
+	} {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = LoadConfig(path)
+		if err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+		if strings.HasPrefix(name, "delimiter") && !strings.Contains(err.Error(), "Terminator and Delimiter") {
+			t.Fatalf("%s: expected the error from Validate, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/types"
+)
+
+type msgTypeKey struct{}
+type settingsKey struct{}
+type lastResponseKey struct{}
+type sessionKey struct{}
+type institutionKey struct{}
+type accountKey struct{}
+
+// MsgTypeFromContext returns the type of the request being handled.
+func MsgTypeFromContext(ctx context.Context) types.MsgType {
+	msgType, _ := ctx.Value(msgTypeKey{}).(types.MsgType)
+	return msgType
+}
+
+// SettingsFromContext returns the server Settings that apply to the request being handled.
+func SettingsFromContext(ctx context.Context) Settings {
+	s, _ := ctx.Value(settingsKey{}).(Settings)
+	return s
+}
+
+// SessionFromContext returns the Session of the connection the request arrived on.
+func SessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
+
+// InstitutionFromContext returns the Institution the request was routed to, or nil if it was not routed to one.
+func InstitutionFromContext(ctx context.Context) *Institution {
+	inst, _ := ctx.Value(institutionKey{}).(*Institution)
+	return inst
+}
+
+// AccountFromContext returns the account the Authenticator accepted for the SC Login being handled. It is only set for SC Login handlers, and only when there is an Authenticator; once logged in, the account is available from the Session.
+func AccountFromContext(ctx context.Context) (*Account, bool) {
+	account, ok := ctx.Value(accountKey{}).(*Account)
+	return account, ok
+}
+
+// LastResponseFromContext returns the last response sent on the connection, or nil if nothing has been sent yet.
+func LastResponseFromContext(ctx context.Context) response.Response {
+	resp, _ := ctx.Value(lastResponseKey{}).(response.Response)
+	return resp
+}
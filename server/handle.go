@@ -2,222 +2,452 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"reflect"
+	"runtime/debug"
+	"strings"
 	"time"
 	"unicode/utf8"
 
 	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
 	"github.com/pescew/sip/types"
 	"github.com/pescew/sip/utils"
 )
 
-func (server *Server) handleConnection(src *net.TCPConn) {
-	defer src.Close()
+func (server *Server) handleConnection(c *conn) {
+	src := c.rwc
+	defer func() {
+		c.state.Store(int32(stateClosed))
+		src.Close()
+		server.trackConn(c, false)
+	}()
 
-	if server.debugMode {
-		log.Printf(fmt.Sprintf("Handling Connection from: %s\n", src.RemoteAddr().String()))
+	c.logger.Debug("handling connection")
+
+	if server.admit(c) {
+		defer server.limiter.release(c.ip)
+	} else if server.config().limitAction != LimitReject {
+		return
 	}
 
 	lineScanner := utils.GenerateLineScanner(server.terminatorCharacter)
 
-	src.SetDeadline(time.Now().Add(time.Second * time.Duration(server.connectionTimeout)))
+	if tlsConn, ok := src.(*tls.Conn); ok {
+		tlsConn.SetDeadline(server.deadline(c, server.config().connectionTimeout))
+		err := tlsConn.Handshake()
+		if err != nil {
+			c.logger.Warn("TLS handshake failed", "error", err)
+			return
+		}
+		server.tlsLogin(c, tlsConn.ConnectionState())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	r := bufio.NewReader(src)
-	scanner := bufio.NewScanner(r)
+	var r io.Reader = src
+	if c.telnet {
+		r = &telnetReader{r: src}
+	}
+	scanner := bufio.NewScanner(bufio.NewReader(r))
 	scanner.Split(lineScanner)
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	if c.telnet && (!c.admitted || !server.telnetLogin(ctx, c, scanner)) {
+		return
+	}
 
-		if utf8.RuneCountInString(line) < 2 {
-			if server.debugMode {
-				log.Println("Closing connection")
-			}
-			continue
+	for {
+		src.SetReadDeadline(server.deadline(c, server.config().connectionTimeout))
+		if !scanner.Scan() {
+			break
 		}
 
-		req, msgID, err := request.Unmarshal(line, server.delimiterCharacter, server.terminatorCharacter)
-		if err != nil {
-			log.Printf(fmt.Sprintf("Error reading SIP request: %s\n", err.Error()))
-			continue
+		if !c.state.CompareAndSwap(int32(stateIdle), int32(stateActive)) {
+			return
 		}
 
-		if server.debugMode {
-			log.Printf(fmt.Sprintf("Request MsgID %s: %s\n", msgID, line))
+		if !c.admitted {
+			server.sendNegativeResponse(c, scanner.Text())
+			return
 		}
 
-		switch msgID {
-		case types.ReqBlockPatron.ID():
-			if server.handleBlockPatron != nil {
-				server.handleBlockPatron(src, req.(*request.BlockPatron), server.settings)
-			}
-		case types.ReqCheckin.ID():
-			if server.handleCheckin != nil {
-				server.handleCheckin(src, req.(*request.Checkin), server.settings)
-			}
-		case types.ReqCheckout.ID():
-			if server.handleCheckout != nil {
-				server.handleCheckout(src, req.(*request.Checkout), server.settings)
-			}
-		case types.ReqHold.ID():
-			if server.handleHold != nil {
-				server.handleHold(src, req.(*request.Hold), server.settings)
-			}
-		case types.ReqItemInfo.ID():
-			if server.handleItemInfo != nil {
-				server.handleItemInfo(src, req.(*request.ItemInfo), server.settings)
-			}
-		case types.ReqItemStatusUpdate.ID():
-			if server.handleItemStatusUpdate != nil {
-				server.handleItemStatusUpdate(src, req.(*request.ItemStatusUpdate), server.settings)
-			}
-		case types.ReqPatronStatus.ID():
-			if server.handlePatronStatus != nil {
-				server.handlePatronStatus(src, req.(*request.PatronStatus), server.settings)
-			}
-		case types.ReqPatronEnable.ID():
-			if server.handlePatronEnable != nil {
-				server.handlePatronEnable(src, req.(*request.PatronEnable), server.settings)
-			}
-		case types.ReqRenew.ID():
-			if server.handleRenew != nil {
-				server.handleRenew(src, req.(*request.Renew), server.settings)
-			}
-		case types.ReqEndPatronSession.ID():
-			if server.handleEndPatronSession != nil {
-				server.handleEndPatronSession(src, req.(*request.EndPatronSession), server.settings)
-			}
-		case types.ReqFeePaid.ID():
-			if server.handleFeePaid != nil {
-				server.handleFeePaid(src, req.(*request.FeePaid), server.settings)
-			}
-		case types.ReqPatronInfo.ID():
-			if server.handlePatronInfo != nil {
-				server.handlePatronInfo(src, req.(*request.PatronInfo), server.settings)
-			}
-		case types.ReqRenewAll.ID():
-			if server.handleRenewAll != nil {
-				server.handleRenewAll(src, req.(*request.RenewAll), server.settings)
-			}
-		case types.ReqSCLogin.ID():
-			if server.handleSCLogin != nil {
-				server.handleSCLogin(src, req.(*request.SCLogin), server.settings)
-			}
-		case types.ReqACSResend.ID():
-			if server.handleACSResend != nil {
-				server.handleACSResend(src, req.(*request.ACSResend), server.settings)
-			}
-		case types.ReqSCStatus.ID():
-			if server.handleSCStatus != nil {
-				server.handleSCStatus(src, req.(*request.SCStatus), server.settings)
-			}
-		default:
-			log.Printf(fmt.Sprintf("Unknown MsgID: %s", msgID))
-			continue
+		server.handleLine(ctx, c, scanner.Text())
+
+		if server.shuttingDown() {
+			return
 		}
+		c.state.Store(int32(stateIdle))
 	}
 
 	err := scanner.Err()
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		c.logger.Debug("closing connection after timeout", "error", err)
+	} else if err != nil && !server.shuttingDown() {
+		c.logger.Warn("invalid scanner input", "error", err)
+	}
+}
+
+// errMissingChecksum is the error for a request without a checksum where one is required, see RequireErrorDetection.
+var errMissingChecksum = fmt.Errorf("SIP request is missing its checksum")
+
+func (server *Server) handleLine(ctx context.Context, c *conn, line string) {
+	if utf8.RuneCountInString(line) < 2 {
+		c.logger.Debug("closing connection")
+		return
+	}
+
+	if !server.allowMessage(c, line) {
+		return
+	}
+
+	start := time.Now()
+	cfg := server.config()
+	logger := c.logger
+
+	// The whole message is handled with the configuration current when it arrived, even if Reload replaces it meanwhile.
+	c.cfg = cfg
+	defer func() {
+		c.cfg = nil
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("recovered from panic handling SIP message", "line", server.redactedLine(line), "panic", r, "stack", string(debug.Stack()))
+			server.sendErrorResponse(c, line)
+		}
+	}()
+
+	var req request.Request
+	var msgID string
+	var err error
+	if cfg.errorDetection {
+		var ed utils.ErrorDetection
+		req, msgID, ed, err = request.UnmarshalVerified(line, server.delimiterCharacter, server.terminatorCharacter)
+		if !ed.ChecksumPresent && (c.checksummed || cfg.requireErrorDetection) && !strings.HasPrefix(line, types.ReqACSResend.ID()) {
+			err = errMissingChecksum
+		}
+		if ed.ChecksumPresent {
+			c.checksummed = true
+		}
+		if errors.Is(err, utils.ErrChecksumMismatch) || errors.Is(err, utils.ErrInvalidSeqNum) || err == errMissingChecksum {
+			logger.Warn("requesting SC Resend", "error", err)
+			server.metrics.checksumFailures.Add(1)
+			server.write(c, (&response.SCResend{}).Marshal(server.delimiterCharacter, server.terminatorCharacter, cfg.errorDetection))
+			return
+		}
+	} else {
+		req, msgID, err = request.Unmarshal(line, server.delimiterCharacter, server.terminatorCharacter)
+	}
+	if err != nil {
+		logger.Warn("error reading SIP request", "error", err, "line", server.redactedLine(line))
+		server.metrics.parseFailures.Add(1)
+		server.sendErrorResponse(c, line)
+		return
+	}
+
+	msgType, ok := types.FromID(msgID)
+	if !ok {
+		logger.Warn("unknown MsgID", "msg_id", msgID)
+		return
+	}
+
+	logger = logger.With("msg_type", msgType.String(), "seq_num", req.GetSeqNum(), "institution", institutionID(req))
+	logger.Debug("SIP request", "line", server.redactedLine(line), "request", req)
+	defer func() {
+		latency := time.Since(start)
+		server.metrics.observeRequest(msgType, latency)
+		logger.Debug("handled SIP request", "latency", latency)
+	}()
+
+	if cfg.requireLogin && !c.session.LoggedIn() && msgType != types.ReqSCLogin && msgType != types.ReqSCStatus {
+		logger.Warn("refusing request before SC Login")
+		server.sendErrorResponse(c, line)
+		return
+	}
+
+	if scStatus, ok := req.(*request.SCStatus); ok {
+		c.session.setMaxPrintWidth(scStatus.MaxPrintWidth)
+	}
+
+	resp, err := server.dispatch(ctx, c, msgType, req)
 	if err != nil {
-		log.Printf(fmt.Sprintf("Invalid scanner input: %s", err.Error()))
+		logger.Error("error handling SIP request", "error", err)
+		server.sendErrorResponse(c, line)
+		return
+	}
+	if resp == nil {
+		return
 	}
 
+	server.respond(c, msgType, req.GetSeqNum(), resp)
 }
 
-func (server *Server) HandleBlockPatron(handleFunc func(conn *net.TCPConn, r *request.BlockPatron, s Settings)) {
-	server.mu.Lock()
-	server.handleBlockPatron = handleFunc
-	server.mu.Unlock()
+// dispatch passes req to the handler for msgType, going through the Institution it is routed to and the middleware, and records the outcome of an SC Login on the session.
+func (server *Server) dispatch(ctx context.Context, c *conn, msgType types.MsgType, req request.Request) (response.Response, error) {
+	c.loginAccount = nil
+
+	cfg := server.connConfig(c)
+	settings := cfg.settings
+	var handler HandlerFunc
+	inst := server.route(c, req)
+	if inst != nil {
+		settings = inst.settings(settings)
+		handler = inst.handler(msgType)
+	}
+	if handler == nil && cfg.handlers != nil {
+		handler = cfg.handlers.handler(msgType)
+	} else if handler == nil {
+		handler = server.handler(msgType)
+	}
+	if handler == nil {
+		handler = server.defaultHandler(c, msgType)
+	}
+	if msgType == types.ReqSCLogin && cfg.authenticator != nil {
+		handler = authenticate(c, cfg.authenticator, handler)
+	}
+	handler = server.applyMiddleware(handler, cfg.middleware)
+
+	ctx = context.WithValue(ctx, msgTypeKey{}, msgType)
+	ctx = context.WithValue(ctx, settingsKey{}, settings)
+	ctx = context.WithValue(ctx, institutionKey{}, inst)
+	ctx = context.WithValue(ctx, lastResponseKey{}, c.lastResponse)
+	ctx = context.WithValue(ctx, sessionKey{}, c.session)
+
+	resp, err := handler(ctx, req)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+
+	if scLogin, ok := req.(*request.SCLogin); ok {
+		if login, ok := resp.(*response.SCLogin); ok {
+			c.session.setLogin(login.Ok, scLogin.LoginUserID, scLogin.LocationCode, c.loginAccount)
+		}
+	}
+
+	return resp, nil
 }
 
-func (server *Server) HandleCheckin(handleFunc func(conn *net.TCPConn, r *request.Checkin, s Settings)) {
-	server.mu.Lock()
-	server.handleCheckin = handleFunc
-	server.mu.Unlock()
+// tlsLogin records the terminal identity of a verified client certificate and, with TLSClientCertLogin, logs the session in as that terminal. If there is an Authenticator, the identity must be the login user ID of one of its accounts, and the login is checked against the account's allowed networks like an SC Login. A panic in the account lookup is recovered and logged, and leaves the connection logged out.
+func (server *Server) tlsLogin(c *conn, state tls.ConnectionState) {
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return
+	}
+
+	identity := state.PeerCertificates[0].Subject.CommonName
+	c.session.setTLSIdentity(identity)
+
+	cfg := server.config()
+	if !cfg.tlsClientCertLogin || identity == "" {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Error("recovered from panic handling TLS client certificate login", "identity", identity, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	var account *Account
+	if cfg.authenticator != nil {
+		lookup, ok := cfg.authenticator.(AccountLookup)
+		if !ok {
+			c.logger.Warn("TLS client certificate login refused: the Authenticator cannot look up accounts", "identity", identity)
+			return
+		}
+
+		account, ok = lookup.LookupAccount(identity)
+		if !ok {
+			c.logger.Warn("TLS client certificate login refused: unknown account", "identity", identity)
+			return
+		}
+
+		if !account.Allows(c.ip) {
+			c.logger.Warn("TLS client certificate login refused: source IP not in allowed networks", "identity", identity)
+			return
+		}
+	}
+
+	c.session.setLogin(true, identity, "", account)
+	c.logger.Debug("logged in by TLS client certificate", "identity", identity)
 }
 
-func (server *Server) HandleCheckout(handleFunc func(conn *net.TCPConn, r *request.Checkout, s Settings)) {
-	server.mu.Lock()
-	server.handleCheckout = handleFunc
-	server.mu.Unlock()
+// respond marshals resp with seqNum and writes it to c, keeping it as the last response for ACS Resend.
+func (server *Server) respond(c *conn, msgType types.MsgType, seqNum int, resp response.Response) {
+	// A resent message keeps the sequence number it was originally sent with.
+	if msgType != types.ReqACSResend {
+		resp.SetSeqNum(seqNum)
+	}
+	respString := resp.Marshal(server.delimiterCharacter, server.terminatorCharacter, server.connConfig(c).errorDetection)
+
+	c.logger.Debug("SIP response", "msg_type", msgType.String(), "line", server.redactedLine(respString))
+
+	if server.write(c, respString) && msgType != types.ReqACSResend {
+		c.lastResponse = resp
+		c.lastResponseString = respString
+		server.metrics.observeResponse(msgType, resp)
+	}
 }
 
-func (server *Server) HandleHold(handleFunc func(conn *net.TCPConn, r *request.Hold, s Settings)) {
-	server.mu.Lock()
-	server.handleHold = handleFunc
-	server.mu.Unlock()
+// sendErrorResponse answers line with a negative response when SendErrorResponses is enabled, so that the SC is not left waiting for a reply.
+func (server *Server) sendErrorResponse(c *conn, line string) {
+	if !server.connConfig(c).sendErrorResponses {
+		return
+	}
+	server.sendNegativeResponse(c, line)
 }
 
-func (server *Server) HandleItemInfo(handleFunc func(conn *net.TCPConn, r *request.ItemInfo, s Settings)) {
-	server.mu.Lock()
-	server.handleItemInfo = handleFunc
-	server.mu.Unlock()
+// sendNegativeResponse answers line with the negative response for its message type.
+func (server *Server) sendNegativeResponse(c *conn, line string) {
+	if utf8.RuneCountInString(line) < 2 {
+		return
+	}
+
+	msgType, ok := types.FromID(string([]rune(line)[0:2]))
+	if !ok {
+		return
+	}
+
+	resp := server.negativeResponse(server.connConfig(c), msgType, line)
+	if resp == nil {
+		return
+	}
+
+	server.respond(c, msgType, utils.CheckErrorDetection(line).SeqNum, resp)
 }
 
-func (server *Server) HandleItemStatusUpdate(handleFunc func(conn *net.TCPConn, r *request.ItemStatusUpdate, s Settings)) {
-	server.mu.Lock()
-	server.handleItemStatusUpdate = handleFunc
-	server.mu.Unlock()
+// authenticate wraps the SC Login handler next so that the Authenticator decides the login first. Logins it refuses, and logins from outside the account's allowed networks, are answered with Ok=false without calling next. For the others next is called with the account in its context, see AccountFromContext, and decides the response.
+func authenticate(c *conn, authenticator Authenticator, next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, req request.Request) (response.Response, error) {
+		r := req.(*request.SCLogin)
+
+		account, err := authenticator.Authenticate(ctx, r)
+		if err != nil {
+			c.logger.Warn("SC Login refused", "login_user_id", r.LoginUserID, "error", err)
+			return &response.SCLogin{Ok: false}, nil
+		}
+
+		if !account.Allows(c.ip) {
+			c.logger.Warn("SC Login refused: source IP not in allowed networks", "login_user_id", r.LoginUserID)
+			return &response.SCLogin{Ok: false}, nil
+		}
+
+		c.loginAccount = account
+		return next(context.WithValue(ctx, accountKey{}, account), req)
+	}
 }
 
-func (server *Server) HandlePatronStatus(handleFunc func(conn *net.TCPConn, r *request.PatronStatus, s Settings)) {
-	server.mu.Lock()
-	server.handlePatronStatus = handleFunc
-	server.mu.Unlock()
+// defaultHandler returns the handler used for msgType when none is registered. ACS Resend requests are answered by retransmitting the last message sent on c exactly as it was written, SC Login requests that the Authenticator accepted are answered with Ok=true, and everything else is left unanswered.
+func (server *Server) defaultHandler(c *conn, msgType types.MsgType) HandlerFunc {
+	if msgType == types.ReqSCLogin && server.connConfig(c).authenticator != nil {
+		return func(ctx context.Context, req request.Request) (response.Response, error) {
+			return &response.SCLogin{Ok: true}, nil
+		}
+	}
+
+	if msgType == types.ReqACSResend {
+		return func(ctx context.Context, req request.Request) (response.Response, error) {
+			if c.lastResponseString == "" {
+				c.logger.Debug("ACS Resend requested before any response was sent")
+				return nil, nil
+			}
+			return &resentResponse{Response: c.lastResponse, line: c.lastResponseString}, nil
+		}
+	}
+
+	return func(ctx context.Context, req request.Request) (response.Response, error) {
+		c.logger.Debug("no handler registered", "msg_type", msgType.String())
+		return nil, nil
+	}
 }
 
-func (server *Server) HandlePatronEnable(handleFunc func(conn *net.TCPConn, r *request.PatronEnable, s Settings)) {
-	server.mu.Lock()
-	server.handlePatronEnable = handleFunc
-	server.mu.Unlock()
+// resentResponse is a previously sent response that marshals to exactly the line originally written.
+type resentResponse struct {
+	response.Response
+	line string
 }
 
-func (server *Server) HandleRenew(handleFunc func(conn *net.TCPConn, r *request.Renew, s Settings)) {
-	server.mu.Lock()
-	server.handleRenew = handleFunc
-	server.mu.Unlock()
+func (rr *resentResponse) Marshal(delimiter, terminator rune, errorDetection bool) string {
+	return rr.line
 }
 
-func (server *Server) HandleEndPatronSession(handleFunc func(conn *net.TCPConn, r *request.EndPatronSession, s Settings)) {
-	server.mu.Lock()
-	server.handleEndPatronSession = handleFunc
-	server.mu.Unlock()
+// deadline returns the time timeout seconds from now, capped at the connection's maximum lifetime.
+func (server *Server) deadline(c *conn, timeout int) time.Time {
+	t := time.Now().Add(time.Second * time.Duration(timeout))
+	if !c.expires.IsZero() && c.expires.Before(t) {
+		return c.expires
+	}
+	return t
 }
 
-func (server *Server) HandleFeePaid(handleFunc func(conn *net.TCPConn, r *request.FeePaid, s Settings)) {
-	server.mu.Lock()
-	server.handleFeePaid = handleFunc
-	server.mu.Unlock()
+func (server *Server) write(c *conn, msg string) bool {
+	c.rwc.SetWriteDeadline(server.deadline(c, server.connConfig(c).writeTimeout))
+	_, err := c.rwc.Write([]byte(msg))
+	if err != nil {
+		c.logger.Warn("error writing SIP response", "error", err)
+		return false
+	}
+	return true
 }
 
-func (server *Server) HandlePatronInfo(handleFunc func(conn *net.TCPConn, r *request.PatronInfo, s Settings)) {
-	server.mu.Lock()
-	server.handlePatronInfo = handleFunc
-	server.mu.Unlock()
+// HandlerFunc is the untyped form of a message handler. Handlers registered with the typed Handle* methods are stored as a HandlerFunc. A nil response with a nil error sends nothing back to the SC.
+type HandlerFunc func(ctx context.Context, req request.Request) (response.Response, error)
+
+// adapt converts a typed handler into a HandlerFunc.
+func adapt[Req request.Request, Resp response.Response](handleFunc func(ctx context.Context, r Req) (Resp, error)) HandlerFunc {
+	if handleFunc == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, req request.Request) (response.Response, error) {
+		r, ok := req.(Req)
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected request type %T", request.ErrInvalidRequest, req)
+		}
+
+		resp, err := handleFunc(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+
+		var zero Resp
+		if any(resp) == any(zero) {
+			return nil, nil
+		}
+		return resp, nil
+	}
 }
 
-func (server *Server) HandleRenewAll(handleFunc func(conn *net.TCPConn, r *request.RenewAll, s Settings)) {
-	server.mu.Lock()
-	server.handleRenewAll = handleFunc
-	server.mu.Unlock()
+// redactedLine is a raw SIP line that is logged with the values of utils.SensitiveFields masked.
+type redactedLine struct {
+	line      string
+	delimiter rune
 }
 
-func (server *Server) HandleSCLogin(handleFunc func(conn *net.TCPConn, r *request.SCLogin, s Settings)) {
-	server.mu.Lock()
-	server.handleSCLogin = handleFunc
-	server.mu.Unlock()
+func (rl redactedLine) LogValue() slog.Value {
+	return slog.StringValue(utils.RedactFields(strings.TrimRight(rl.line, "\r\n"), rl.delimiter))
 }
 
-func (server *Server) HandleACSResend(handleFunc func(conn *net.TCPConn, r *request.ACSResend, s Settings)) {
-	server.mu.Lock()
-	server.handleACSResend = handleFunc
-	server.mu.Unlock()
+func (server *Server) redactedLine(line string) redactedLine {
+	return redactedLine{line: line, delimiter: server.delimiterCharacter}
 }
 
-func (server *Server) HandleSCStatus(handleFunc func(conn *net.TCPConn, r *request.SCStatus, s Settings)) {
-	server.mu.Lock()
-	server.handleSCStatus = handleFunc
-	server.mu.Unlock()
+// institutionID returns the Institution ID of req, or an empty string if it has none.
+func institutionID(req request.Request) string {
+	v := reflect.Indirect(reflect.ValueOf(req))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	field := v.FieldByName("InstitutionID")
+	if field.Kind() != reflect.String {
+		return ""
+	}
+	return field.String()
 }
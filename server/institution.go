@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pescew/sip/request"
+)
+
+// InstitutionConfig describes a member institution served from the same endpoint as the others.
+type InstitutionConfig struct {
+	InstitutionID string
+	LibraryID     string
+
+	// ACS Status values sent by DefaultSCStatusHandler for this institution.
+	StatusTimeoutPeriod  int
+	StatusRetriesAllowed int
+	StatusOfflineOK      bool
+}
+
+// Institution is a member institution with its own handlers and settings. A request is routed to an institution by the Institution ID of the Account the SC logged in with or, if that is not set, by the AO field of the request. Routed requests use the institution's handlers, falling back to the server's for message types it has no handler for, and SettingsFromContext returns the server Settings with the institution's values in place.
+type Institution struct {
+	*Mux
+	cfg InstitutionConfig
+}
+
+// ID returns the Institution ID requests are routed by.
+func (inst *Institution) ID() string {
+	return inst.cfg.InstitutionID
+}
+
+// settings returns base with the institution's values in place.
+func (inst *Institution) settings(base Settings) Settings {
+	base.institutionID = inst.cfg.InstitutionID
+	base.libraryID = inst.cfg.LibraryID
+	base.statusTimeoutPeriod = inst.cfg.StatusTimeoutPeriod
+	base.statusRetriesAllowed = inst.cfg.StatusRetriesAllowed
+	base.statusOfflineOK = inst.cfg.StatusOfflineOK
+	return base
+}
+
+// AddInstitution adds a member institution and returns it so that its handlers can be registered. Institutions can be added while the server is running.
+func (server *Server) AddInstitution(cfg InstitutionConfig) (*Institution, error) {
+	if cfg.InstitutionID == "" {
+		return nil, fmt.Errorf("invalid institution - Institution ID is required")
+	}
+
+	for name, value := range map[string]string{"Institution ID": cfg.InstitutionID, "Library ID": cfg.LibraryID} {
+		if strings.ContainsRune(value, server.terminatorCharacter) {
+			return nil, fmt.Errorf("cannot use Terminator Character in %s: %s", name, string(server.terminatorCharacter))
+		} else if strings.ContainsRune(value, server.delimiterCharacter) {
+			return nil, fmt.Errorf("cannot use Delimiter Character in %s: %s", name, string(server.delimiterCharacter))
+		}
+	}
+
+	if cfg.StatusTimeoutPeriod < 0 || cfg.StatusTimeoutPeriod > 999 {
+		return nil, fmt.Errorf("invalid status timeout period - must be between 0-999")
+	}
+
+	if cfg.StatusRetriesAllowed < 0 || cfg.StatusRetriesAllowed > 999 {
+		return nil, fmt.Errorf("invalid status retries allowed - must be between 0-999")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if _, exists := server.institutions[cfg.InstitutionID]; exists {
+		return nil, fmt.Errorf("institution %s already exists", cfg.InstitutionID)
+	}
+
+	inst := &Institution{Mux: NewMux(), cfg: cfg}
+	server.institutions[cfg.InstitutionID] = inst
+	return inst, nil
+}
+
+// RemoveInstitution removes the member institution with institutionID. Its requests are then handled by the server's handlers.
+func (server *Server) RemoveInstitution(institutionID string) {
+	server.mu.Lock()
+	delete(server.institutions, institutionID)
+	server.mu.Unlock()
+}
+
+// route returns the institution req on c is routed to, or nil if it is not routed to one.
+func (server *Server) route(c *conn, req request.Request) *Institution {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.institutions) == 0 {
+		return nil
+	}
+
+	// A terminal account belongs to one institution, so it takes precedence over the AO field.
+	if account, ok := c.session.Account(); ok && account.InstitutionID != "" {
+		return server.institutions[account.InstitutionID]
+	}
+
+	return server.institutions[institutionID(req)]
+}
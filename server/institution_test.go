@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+func TestInstitutionRouting(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := NewAccountTable(Account{LoginUserID: "north-kiosk", PasswordHash: hash, InstitutionID: "north"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Authenticator = accounts
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patronInfo := func(name string) func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		return func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+			settings := SettingsFromContext(ctx)
+			return &response.PatronInfo{
+				TransactionDate: time.Now(),
+				InstitutionID:   settings.InstitutionID(),
+				PatronID:        r.PatronID,
+				PatronName:      name,
+			}, nil
+		}
+	}
+
+	srv.HandlePatronInfo(patronInfo("shared"))
+	srv.HandleSCStatus(srv.DefaultSCStatusHandler)
+
+	north, err := srv.AddInstitution(InstitutionConfig{InstitutionID: "north", LibraryID: "North Library", StatusTimeoutPeriod: 60, StatusRetriesAllowed: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	north.HandlePatronInfo(patronInfo("north"))
+	north.HandleCheckin(func(ctx context.Context, r *request.Checkin) (*response.Checkin, error) {
+		return nil, nil
+	})
+
+	_, err = srv.AddInstitution(InstitutionConfig{InstitutionID: "south", LibraryID: "South Library"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = srv.AddInstitution(InstitutionConfig{InstitutionID: "south"})
+	if err == nil {
+		t.Fatalf("expected an error adding an institution twice")
+	}
+
+	addr := serveTest(t, srv)
+
+	c, err := client.Dial(addr, client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, tc := range []struct {
+		ao            string
+		name          string
+		institutionID string
+	}{
+		{"north", "north", "north"},
+		{"south", "shared", "south"},
+		{"west", "shared", "inst"},
+	} {
+		info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: tc.ao, PatronID: "johndoe"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.PatronName != tc.name || info.InstitutionID != tc.institutionID {
+			t.Fatalf("AO %s: routed to %s with Institution ID %s", tc.ao, info.PatronName, info.InstitutionID)
+		}
+	}
+
+	status, err := c.Status(&request.SCStatus{MaxPrintWidth: 40, ProtocolVersion: "2.00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.InstitutionID != "inst" || status.SupportedMessages.Checkin {
+		t.Fatalf("unexpected ACS Status before login: %+v", status)
+	}
+
+	login, err := c.Login(&request.SCLogin{LoginUserID: "north-kiosk", LoginPassword: "secret"})
+	if err != nil || !login.Ok {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	status, err = c.Status(&request.SCStatus{MaxPrintWidth: 40, ProtocolVersion: "2.00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.InstitutionID != "north" || status.LibraryName != "North Library" || status.TimeoutPeriod != 60 || status.RetriesAllowed != 5 || !status.SupportedMessages.Checkin {
+		t.Fatalf("unexpected ACS Status for the terminal account's institution: %+v", status)
+	}
+
+	info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "south", PatronID: "johndoe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PatronName != "north" {
+		t.Fatalf("terminal account's institution did not take precedence over AO: %s", info.PatronName)
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LimitAction is what the server does with a connection or message that exceeds a configured limit.
+type LimitAction int
+
+const (
+	// LimitDrop closes a connection over the connection limits, and discards a message over the message rate without answering it.
+	LimitDrop LimitAction = iota
+	// LimitDelay holds a connection over the connection limits until a slot frees up or ConnectionTimeout passes, and holds a message over the message rate until the rate allows it.
+	LimitDelay
+	// LimitReject answers the first message of a connection over the connection limits, or a message over the message rate, with a negative response carrying ErrorScreenMessage. The connection is then closed in the first case.
+	LimitReject
+)
+
+func (la LimitAction) String() string {
+	switch la {
+	case LimitDrop:
+		return "drop"
+	case LimitDelay:
+		return "delay"
+	case LimitReject:
+		return "reject"
+	}
+	return fmt.Sprintf("LimitAction(%d)", int(la))
+}
+
+// ParseLimitAction returns the LimitAction named by s: drop, delay or reject.
+func ParseLimitAction(s string) (LimitAction, error) {
+	for _, la := range []LimitAction{LimitDrop, LimitDelay, LimitReject} {
+		if strings.EqualFold(s, la.String()) {
+			return la, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown limit action: %s", s)
+}
+
+// limits are the connection limits and the per source IP message rate. They are part of the reloadable serverConfig, so that a connection or message is always checked against limits from a single configuration.
+type limits struct {
+	maxConns      int
+	maxConnsPerIP int
+	rate          float64
+	burst         float64
+}
+
+func newLimits(maxConns, maxConnsPerIP int, rate float64, burst int) limits {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return limits{
+		maxConns:      maxConns,
+		maxConnsPerIP: maxConnsPerIP,
+		rate:          rate,
+		burst:         float64(burst),
+	}
+}
+
+// limiter keeps the state needed to enforce limits: the connections open in total and per source IP, and the message bucket of each source IP. Connections without a source IP, such as those over a Unix socket, only count towards the total. Connections already holding a slot keep it when the limits are lowered.
+type limiter struct {
+	mu    sync.Mutex
+	conns int
+	ips   map[netip.Addr]*ipLimit
+}
+
+// ipLimit is the state kept for one source IP. Its message bucket is kept after the last connection closes until it has refilled, so that reconnecting does not reset the rate.
+type ipLimit struct {
+	conns  int
+	tokens float64
+	last   time.Time
+}
+
+// pruneThreshold is the number of tracked source IPs above which idle entries are removed.
+const pruneThreshold = 1024
+
+func newLimiter() *limiter {
+	return &limiter{
+		ips: make(map[netip.Addr]*ipLimit),
+	}
+}
+
+// ipLocked returns the state for ip, creating it with a full bucket if needed. l.mu must be held.
+func (l *limiter) ipLocked(lim limits, ip netip.Addr, now time.Time) *ipLimit {
+	il, ok := l.ips[ip]
+	if !ok {
+		if len(l.ips) >= pruneThreshold {
+			l.pruneLocked(lim, now)
+		}
+		il = &ipLimit{tokens: lim.burst, last: now}
+		l.ips[ip] = il
+	}
+	return il
+}
+
+// refillLocked adds the tokens earned since the last message. l.mu must be held.
+func (l *limiter) refillLocked(lim limits, il *ipLimit, now time.Time) {
+	if lim.rate > 0 {
+		il.tokens = min(lim.burst, il.tokens+now.Sub(il.last).Seconds()*lim.rate)
+	}
+	il.last = now
+}
+
+// pruneLocked removes source IPs with no connections and a full bucket. l.mu must be held.
+func (l *limiter) pruneLocked(lim limits, now time.Time) {
+	for ip, il := range l.ips {
+		if il.conns > 0 {
+			continue
+		}
+		l.refillLocked(lim, il, now)
+		if il.tokens >= lim.burst {
+			delete(l.ips, ip)
+		}
+	}
+}
+
+// acquire takes a connection slot for ip and reports whether one was available under lim.
+func (l *limiter) acquire(lim limits, ip netip.Addr) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lim.maxConns > 0 && l.conns >= lim.maxConns {
+		return false
+	}
+
+	if !ip.IsValid() {
+		l.conns++
+		return true
+	}
+
+	il := l.ipLocked(lim, ip, time.Now())
+	if lim.maxConnsPerIP > 0 && il.conns >= lim.maxConnsPerIP {
+		return false
+	}
+
+	l.conns++
+	il.conns++
+	return true
+}
+
+// release returns a connection slot taken by acquire.
+func (l *limiter) release(ip netip.Addr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.conns--
+	if il, ok := l.ips[ip]; ok {
+		il.conns--
+	}
+}
+
+// reserve takes a message token for ip and returns how long to wait before the message is within the rate. A message that has to wait still consumes its token, so the caller must either wait or accept that the rate was spent.
+func (l *limiter) reserve(lim limits, ip netip.Addr) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lim.rate <= 0 || !ip.IsValid() {
+		return 0
+	}
+
+	now := time.Now()
+	il := l.ipLocked(lim, ip, now)
+	l.refillLocked(lim, il, now)
+
+	il.tokens--
+	if il.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-il.tokens / lim.rate * float64(time.Second))
+}
+
+// allow takes a message token for ip if one is available.
+func (l *limiter) allow(lim limits, ip netip.Addr) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lim.rate <= 0 || !ip.IsValid() {
+		return true
+	}
+
+	now := time.Now()
+	il := l.ipLocked(lim, ip, now)
+	l.refillLocked(lim, il, now)
+
+	if il.tokens < 1 {
+		return false
+	}
+	il.tokens--
+	return true
+}
+
+// remoteIP returns the source IP of a connection, or the zero Addr for connections that are not over IP.
+func remoteIP(addr net.Addr) netip.Addr {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// admit takes a connection slot for c according to the connection limits. It reports whether c may be served; with LimitReject a refused connection is still answered with one negative response before it is closed.
+func (server *Server) admit(c *conn) bool {
+	cfg := server.config()
+	if server.limiter.acquire(cfg.limits, c.ip) {
+		c.admitted = true
+		return true
+	}
+
+	if cfg.limitAction == LimitDelay {
+		deadline := time.Now().Add(time.Second * time.Duration(cfg.connectionTimeout))
+		ticker := time.NewTicker(limitPollInterval)
+		defer ticker.Stop()
+
+		for time.Now().Before(deadline) && !server.shuttingDown() {
+			<-ticker.C
+			if server.limiter.acquire(server.config().limits, c.ip) {
+				c.admitted = true
+				return true
+			}
+		}
+	}
+
+	c.logger.Warn("connection limit reached, refusing connection", "action", cfg.limitAction.String())
+	return false
+}
+
+// limitPollInterval is how often a delayed connection checks for a free slot.
+const limitPollInterval = 100 * time.Millisecond
+
+// allowMessage applies the message rate limit to line and reports whether it should be handled.
+func (server *Server) allowMessage(c *conn, line string) bool {
+	cfg := server.config()
+	switch cfg.limitAction {
+	case LimitDelay:
+		wait := server.limiter.reserve(cfg.limits, c.ip)
+		if wait > 0 {
+			c.logger.Debug("message rate exceeded, delaying message", "delay", wait)
+			time.Sleep(wait)
+		}
+		return true
+	case LimitReject:
+		if server.limiter.allow(cfg.limits, c.ip) {
+			return true
+		}
+		c.logger.Warn("message rate exceeded, rejecting message")
+		server.sendNegativeResponse(c, line)
+		return false
+	default:
+		if server.limiter.allow(cfg.limits, c.ip) {
+			return true
+		}
+		c.logger.Warn("message rate exceeded, dropping message")
+		return false
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+func limitTestServer(t *testing.T, cfg Config) string {
+	t.Helper()
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: true}, nil
+	})
+
+	return serveTest(t, srv)
+}
+
+func TestConnectionLimits(t *testing.T) {
+	for _, action := range []LimitAction{LimitDrop, LimitDelay, LimitReject} {
+		cfg := DefaultConfig()
+		cfg.ConnectionTimeout = 1
+		cfg.MaxConnectionsPerIP = 1
+		cfg.LimitAction = action
+
+		addr := limitTestServer(t, cfg)
+
+		first, err := client.Dial(addr, client.DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		login, err := first.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"})
+		if err != nil || !login.Ok {
+			t.Fatalf("%s: first connection refused: %v", action, err)
+		}
+
+		second, err := client.Dial(addr, client.DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer second.Close()
+
+		if action == LimitDelay {
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				first.Close()
+			}()
+		} else {
+			defer first.Close()
+		}
+
+		login, err = second.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"})
+		switch action {
+		case LimitDrop:
+			if err == nil {
+				t.Fatalf("%s: connection over the limit was answered: %+v", action, login)
+			}
+		case LimitDelay:
+			if err != nil || !login.Ok {
+				t.Fatalf("%s: delayed connection was not served once a slot freed up: %v", action, err)
+			}
+		case LimitReject:
+			if err != nil || login.Ok {
+				t.Fatalf("%s: expected negative response, got %+v, %v", action, login, err)
+			}
+		}
+	}
+}
+
+func TestMessageRate(t *testing.T) {
+	checkout := &request.Checkout{
+		TransactionDate: time.Now(),
+		NBDueDate:       time.Now(),
+		InstitutionID:   "inst",
+		PatronID:        "johndoe",
+		ItemID:          "1234567890",
+	}
+
+	cfg := DefaultConfig()
+	cfg.MessageRate = 5
+	cfg.MessageBurst = 2
+	cfg.LimitAction = LimitReject
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleCheckout(func(ctx context.Context, r *request.Checkout) (*response.Checkout, error) {
+		return &response.Checkout{Ok: true, TransactionDate: time.Now(), InstitutionID: r.InstitutionID, PatronID: r.PatronID, ItemID: r.ItemID, DueDate: "tomorrow"}, nil
+	})
+
+	c, err := client.Dial(serveTest(t, srv), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i, expectOk := range []bool{true, true, false} {
+		resp, err := c.Checkout(checkout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Ok != expectOk {
+			t.Fatalf("checkout %d: got Ok=%t", i, resp.Ok)
+		}
+	}
+
+	time.Sleep(250 * time.Millisecond)
+
+	resp, err := c.Checkout(checkout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Ok {
+		t.Fatalf("message rate did not refill")
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// ActivationListeners returns the listening sockets passed to the process by systemd socket activation, in the order given by LISTEN_FDS. It returns no listeners if none were passed to this process. The LISTEN_* environment variables are removed so that child processes do not inherit them.
+func ActivationListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("error using activated socket %s: %v", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// listen opens the listeners ListenAndServe serves.
+func (server *Server) listen() ([]net.Listener, error) {
+	switch {
+	case server.socketActivation:
+		listeners, err := ActivationListeners()
+		if err != nil {
+			return nil, err
+		}
+		if len(listeners) == 0 {
+			return nil, fmt.Errorf("socket activation is enabled but no sockets were passed to the process")
+		}
+		return listeners, nil
+	case server.unixSocket != "":
+		listener, err := listenUnix(server.unixSocket)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	default:
+		listener, err := net.Listen("tcp", server.listenAddr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+}
+
+// listenUnix listens on the Unix socket at path, first removing a socket file that no process is listening on any more.
+func listenUnix(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&fs.ModeSocket != 0 {
+		c, err := net.Dial("unix", path)
+		if err == nil {
+			c.Close()
+			return nil, fmt.Errorf("Unix socket %s is already in use", path)
+		}
+		os.Remove(path)
+	}
+
+	return net.Listen("unix", path)
+}
+
+// validateHost checks that host is an IP address or a well-formed hostname. Hostnames are resolved when the server starts listening.
+func validateHost(host string) error {
+	if host == "" {
+		return nil
+	}
+
+	_, err := netip.ParseAddr(host)
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(host, ":") {
+		return err
+	}
+
+	if len(host) > 253 || strings.ContainsFunc(host, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_')
+	}) {
+		return fmt.Errorf("invalid hostname: %s", host)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+)
+
+// listenAndServeTest runs srv with ListenAndServe and waits until it accepts connections at address.
+func listenAndServeTest(t *testing.T, srv *Server, network, address string) {
+	t.Helper()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.ListenAndServe()
+	}()
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	for {
+		select {
+		case err := <-served:
+			t.Fatalf("ListenAndServe returned %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		conn, err := net.Dial(network, address)
+		if err == nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sip.sock")
+
+	// Leave a stale socket file behind, as a server that crashed would.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := DefaultConfig()
+	cfg.UnixSocket = path
+	cfg.Port = 0
+	cfg.AllowedNetworks = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	cfg.MaxConnectionsPerIP = 1
+
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Authenticator, err = NewAccountTable(Account{
+		LoginUserID:     "kiosk",
+		PasswordHash:    hash,
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.HandleSCStatus(srv.DefaultSCStatusHandler)
+
+	listenAndServeTest(t, srv, "unix", path)
+
+	// Unix socket connections have no source IP, so the allowed networks, the account's allowed networks and the per IP limit do not apply to them.
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := client.New(conn, client.DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		login, err := c.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"})
+		if err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+		if !login.Ok {
+			t.Fatalf("connection %d: login refused", i)
+		}
+
+		_, err = c.Status(&request.SCStatus{MaxPrintWidth: 40, ProtocolVersion: "2.00"})
+		if err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+	}
+
+	_, err = listenUnix(path)
+	if err == nil {
+		t.Fatalf("expected an error listening on a Unix socket that is in use")
+	}
+}
+
+func TestListenHostname(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	cfg := DefaultConfig()
+	cfg.Host = "LocalHost"
+	cfg.Port = port
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.HandleSCStatus(srv.DefaultSCStatusHandler)
+
+	address := net.JoinHostPort("localhost", strconv.Itoa(port))
+	listenAndServeTest(t, srv, "tcp", address)
+
+	c, err := client.Dial(address, client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.Status(&request.SCStatus{MaxPrintWidth: 40, ProtocolVersion: "2.00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateListenAddress(t *testing.T) {
+	for _, tc := range []struct {
+		host string
+		port int
+		ok   bool
+	}{
+		{"", 6001, true},
+		{"0.0.0.0", 6001, true},
+		{"::", 6001, true},
+		{"[::]", 6001, true},
+		{"[::1]", 6001, true},
+		{"sip.example.org", 6001, true},
+		{"localhost", 6001, true},
+		{"sip example", 6001, false},
+		{"::1::2", 6001, false},
+		{"127.0.0.1", 0, false},
+	} {
+		cfg := DefaultConfig()
+		cfg.Host = tc.host
+		cfg.Port = tc.port
+
+		err := cfg.Validate()
+		if (err == nil) != tc.ok {
+			t.Errorf("host %q port %d: got error %v", tc.host, tc.port, err)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.Host = "[::]"
+	if address := cfg.listenAddress(); address != "[::]:9000" {
+		t.Errorf("listen address %s, expected [::]:9000", address)
+	}
+
+	cfg.UnixSocket = "/run/sip.sock"
+	cfg.SocketActivation = true
+	if cfg.Validate() == nil {
+		t.Errorf("expected an error using both a Unix socket and socket activation")
+	}
+}
+
+// TestActivationListeners runs the test binary again with the sockets to activate as its inherited files, which start at file descriptor 3, the same as under systemd. The child process reports what ActivationListeners returned on its standard output.
+func TestActivationListeners(t *testing.T) {
+	if os.Getenv("SIP_TEST_ACTIVATION") != "" {
+		activationChild()
+		return
+	}
+
+	openListener := func() (*os.File, string) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		f, err := listener.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			f.Close()
+		})
+		return f, listener.Addr().String()
+	}
+
+	notSocket, err := os.Create(filepath.Join(t.TempDir(), "not-a-socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notSocket.Close()
+
+	first, firstAddr := openListener()
+	second, secondAddr := openListener()
+
+	for _, tc := range []struct {
+		name   string
+		pid    string
+		fds    string
+		names  string
+		files  []*os.File
+		output string
+	}{
+		{"not activated", "", "", "", nil, "listeners:\n"},
+		{"other process", "1", "1", "", []*os.File{first}, "listeners:\n"},
+		{"no sockets", "self", "0", "", nil, "listeners:\nenv:\n"},
+		{"one socket", "self", "1", "sip", []*os.File{first}, "listeners: " + firstAddr + "\nenv:\n"},
+		{"two sockets", "self", "2", "sip:", []*os.File{first, second}, "listeners: " + firstAddr + " " + secondAddr + "\nenv:\n"},
+		{"invalid count", "self", "many", "", nil, "error: invalid LISTEN_FDS: \"many\"\n"},
+		{"not a socket", "self", "2", "sip:status", []*os.File{first, notSocket}, "error: error using activated socket status"},
+	} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestActivationListeners$")
+		cmd.Env = append(os.Environ(),
+			"SIP_TEST_ACTIVATION=1",
+			"SIP_TEST_LISTEN_PID="+tc.pid,
+			"LISTEN_FDS="+tc.fds,
+			"LISTEN_FDNAMES="+tc.names,
+		)
+		cmd.ExtraFiles = tc.files
+
+		out, err := cmd.Output()
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !strings.HasPrefix(string(out), tc.output) {
+			t.Errorf("%s: got %q, expected %q", tc.name, out, tc.output)
+		}
+	}
+}
+
+// activationChild is the child process side of TestActivationListeners. LISTEN_PID is set here, since the parent cannot know the child's PID before starting it.
+func activationChild() {
+	switch pid := os.Getenv("SIP_TEST_LISTEN_PID"); pid {
+	case "":
+		os.Unsetenv("LISTEN_PID")
+	case "self":
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	default:
+		os.Setenv("LISTEN_PID", pid)
+	}
+
+	listeners, err := ActivationListeners()
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(0)
+	}
+
+	fmt.Print("listeners:")
+	for _, l := range listeners {
+		fmt.Print(" " + l.Addr().String())
+		l.Close()
+	}
+	fmt.Println()
+
+	if os.Getenv("SIP_TEST_LISTEN_PID") == "self" {
+		fmt.Println("env:" + os.Getenv("LISTEN_PID") + os.Getenv("LISTEN_FDS") + os.Getenv("LISTEN_FDNAMES"))
+	}
+	os.Exit(0)
+}
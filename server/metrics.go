@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/types"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics holds the counters exposed by MetricsHandler.
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[types.MsgType]*histogram
+	negatives map[types.MsgType]uint64
+
+	parseFailures    atomic.Uint64
+	checksumFailures atomic.Uint64
+}
+
+// histogram is a cumulative latency histogram over latencyBuckets.
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  make(map[types.MsgType]*histogram),
+		negatives: make(map[types.MsgType]uint64),
+	}
+}
+
+// observeRequest records a handled request of msgType that took latency.
+func (m *metrics) observeRequest(msgType types.MsgType, latency time.Duration) {
+	seconds := latency.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.requests[msgType]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.requests[msgType] = h
+	}
+
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// observeResponse counts resp, sent in reply to a request of msgType, if it refuses the request.
+func (m *metrics) observeResponse(msgType types.MsgType, resp response.Response) {
+	if !refused(resp) {
+		return
+	}
+
+	m.mu.Lock()
+	m.negatives[msgType]++
+	m.mu.Unlock()
+}
+
+// refused reports whether resp tells the SC its request was not carried out, by the flag each response type uses for that. Responses without such a flag, such as Patron Info, are never counted.
+func refused(resp response.Response) bool {
+	switch r := resp.(type) {
+	case *response.SCLogin:
+		return !r.Ok
+	case *response.Checkout:
+		return !r.Ok
+	case *response.Checkin:
+		return !r.Ok
+	case *response.Renew:
+		return !r.Ok
+	case *response.RenewAll:
+		return !r.Ok
+	case *response.Hold:
+		return !r.Ok
+	case *response.EndSession:
+		return !r.EndSession
+	case *response.FeePaid:
+		return !r.PaymentAccepted
+	case *response.ItemStatusUpdate:
+		return !r.ItemPropertiesOk
+	}
+	return false
+}
+
+// MetricsHandler returns an http.Handler that serves the server's metrics in the Prometheus text exposition format. It is served on MetricsAddr when that is configured, and can also be mounted on an existing HTTP server.
+func (server *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		server.writeMetrics(w)
+	})
+}
+
+func (server *Server) writeMetrics(w io.Writer) {
+	m := server.metrics
+
+	openConns, activeSessions := 0, 0
+	server.mu.Lock()
+	for c := range server.activeConns {
+		openConns++
+		if c.session.LoggedIn() {
+			activeSessions++
+		}
+	}
+	server.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msgTypes := make([]types.MsgType, 0, len(m.requests))
+	for msgType := range m.requests {
+		msgTypes = append(msgTypes, msgType)
+	}
+	slices.Sort(msgTypes)
+
+	fmt.Fprintln(w, "# HELP sip_requests_total SIP requests handled, by message type.")
+	fmt.Fprintln(w, "# TYPE sip_requests_total counter")
+	for _, msgType := range msgTypes {
+		fmt.Fprintf(w, "sip_requests_total{%s} %d\n", msgTypeLabels(msgType), m.requests[msgType].count)
+	}
+
+	fmt.Fprintln(w, "# HELP sip_request_duration_seconds Time taken to handle SIP requests, by message type.")
+	fmt.Fprintln(w, "# TYPE sip_request_duration_seconds histogram")
+	for _, msgType := range msgTypes {
+		h := m.requests[msgType]
+		labels := msgTypeLabels(msgType)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "sip_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, strconv.FormatFloat(bound, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(w, "sip_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "sip_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "sip_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	negativeTypes := make([]types.MsgType, 0, len(m.negatives))
+	for msgType := range m.negatives {
+		negativeTypes = append(negativeTypes, msgType)
+	}
+	slices.Sort(negativeTypes)
+
+	fmt.Fprintln(w, "# HELP sip_negative_responses_total Responses refusing the request (Ok, End Session, Payment Accepted or Item Properties Ok false), by request message type.")
+	fmt.Fprintln(w, "# TYPE sip_negative_responses_total counter")
+	for _, msgType := range negativeTypes {
+		fmt.Fprintf(w, "sip_negative_responses_total{%s} %d\n", msgTypeLabels(msgType), m.negatives[msgType])
+	}
+
+	fmt.Fprintln(w, "# HELP sip_parse_failures_total SIP requests that could not be parsed.")
+	fmt.Fprintln(w, "# TYPE sip_parse_failures_total counter")
+	fmt.Fprintf(w, "sip_parse_failures_total %d\n", m.parseFailures.Load())
+
+	fmt.Fprintln(w, "# HELP sip_checksum_failures_total SIP requests that failed error detection and were answered with SC Resend.")
+	fmt.Fprintln(w, "# TYPE sip_checksum_failures_total counter")
+	fmt.Fprintf(w, "sip_checksum_failures_total %d\n", m.checksumFailures.Load())
+
+	fmt.Fprintln(w, "# HELP sip_open_connections Open SC connections.")
+	fmt.Fprintln(w, "# TYPE sip_open_connections gauge")
+	fmt.Fprintf(w, "sip_open_connections %d\n", openConns)
+
+	fmt.Fprintln(w, "# HELP sip_active_sessions Open SC connections that have logged in.")
+	fmt.Fprintln(w, "# TYPE sip_active_sessions gauge")
+	fmt.Fprintf(w, "sip_active_sessions %d\n", activeSessions)
+}
+
+func msgTypeLabels(msgType types.MsgType) string {
+	return fmt.Sprintf("msg_id=%q,msg_type=%q", msgType.ID(), msgType.String())
+}
+
+// serveMetrics starts the metrics HTTP listener on MetricsAddr, once. The listener is closed by Shutdown and Close along with the SIP listeners.
+func (server *Server) serveMetrics() {
+	if server.metricsAddr == "" {
+		return
+	}
+
+	server.metricsOnce.Do(func() {
+		listener, err := net.Listen("tcp", server.metricsAddr)
+		if err != nil {
+			server.config().logger.Error("error starting metrics listener", "addr", server.metricsAddr, "error", err)
+			return
+		}
+
+		if !server.trackListener(listener, true) {
+			listener.Close()
+			return
+		}
+
+		server.config().logger.Info("serving metrics", "addr", listener.Addr().String())
+		go func() {
+			defer server.trackListener(listener, false)
+			http.Serve(listener, server.MetricsHandler())
+		}()
+	})
+}
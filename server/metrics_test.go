@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	srv, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: r.LoginPassword == "secret"}, nil
+	})
+	srv.HandleFeePaid(func(ctx context.Context, r *request.FeePaid) (*response.FeePaid, error) {
+		return &response.FeePaid{TransactionDate: time.Now(), PaymentAccepted: false, InstitutionID: r.InstitutionID, PatronID: r.PatronID}, nil
+	})
+	srv.HandleEndPatronSession(func(ctx context.Context, r *request.EndPatronSession) (*response.EndSession, error) {
+		return &response.EndSession{TransactionDate: time.Now(), EndSession: false, InstitutionID: r.InstitutionID, PatronID: r.PatronID}, nil
+	})
+	srv.HandleItemStatusUpdate(func(ctx context.Context, r *request.ItemStatusUpdate) (*response.ItemStatusUpdate, error) {
+		return &response.ItemStatusUpdate{TransactionDate: time.Now(), ItemPropertiesOk: r.ItemProperties != "withdrawn", ItemID: r.ItemID}, nil
+	})
+
+	conn, err := net.Dial("tcp", serveTest(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	good := (&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret", SeqNum: 1}).Marshal('|', '\r', true)
+	bad := (&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "wrong", SeqNum: 2}).Marshal('|', '\r', true)
+	corrupted := strings.Replace(good, "kiosk", "kiosc", 1)
+	feePaid := (&request.FeePaid{TransactionDate: time.Now(), FeeType: 1, PaymentType: 1, CurrencyType: "USD", FeeAmount: "1.00", InstitutionID: "inst", PatronID: "johndoe", SeqNum: 3}).Marshal('|', '\r', true)
+	endSession := (&request.EndPatronSession{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe", TerminalPassword: "secret", PatronPassword: "pass", SeqNum: 4}).Marshal('|', '\r', true)
+	statusUpdated := (&request.ItemStatusUpdate{TransactionDate: time.Now(), InstitutionID: "inst", ItemID: "1234", ItemProperties: "shelf 2", SeqNum: 5}).Marshal('|', '\r', true)
+	statusRefused := (&request.ItemStatusUpdate{TransactionDate: time.Now(), InstitutionID: "inst", ItemID: "1234", ItemProperties: "withdrawn", SeqNum: 6}).Marshal('|', '\r', true)
+
+	reader := bufio.NewReader(conn)
+	for _, line := range []string{good, bad, corrupted, feePaid, endSession, statusUpdated, statusRefused} {
+		_, err = conn.Write([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = reader.ReadString('\r')
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	srv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+
+	for _, expect := range []string{
+		`sip_requests_total{msg_id="93",msg_type="SC Login Request"} 2`,
+		`sip_request_duration_seconds_count{msg_id="93",msg_type="SC Login Request"} 2`,
+		`sip_request_duration_seconds_bucket{msg_id="93",msg_type="SC Login Request",le="+Inf"} 2`,
+		`sip_negative_responses_total{msg_id="93",msg_type="SC Login Request"} 1`,
+		`sip_negative_responses_total{msg_id="37",msg_type="Fee Paid Request"} 1`,
+		`sip_negative_responses_total{msg_id="35",msg_type="End Patron Session Request"} 1`,
+		`sip_negative_responses_total{msg_id="19",msg_type="Item Status Update Request"} 1`,
+		"sip_checksum_failures_total 1",
+		"sip_parse_failures_total 0",
+		"sip_open_connections 1",
+		"sip_active_sessions 0",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Fatalf("metrics are missing %s:\n%s", expect, out)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package server
+
+import "slices"
+
+// Middleware wraps the dispatch of every request, whether or not a handler is registered for it. The message type, Settings and Session are available from the context passed to the HandlerFunc, and the outgoing response is whatever next returns.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use appends middleware to the chain. The first middleware added is the outermost and sees each request first. Middleware added with Use runs outside any set in Config.Middleware.
+func (server *Server) Use(middleware ...Middleware) {
+	server.mu.Lock()
+	server.middleware = append(server.middleware, middleware...)
+	server.mu.Unlock()
+}
+
+// applyMiddleware wraps handler in the middleware added with Use, outermost, and then configured.
+func (server *Server) applyMiddleware(handler HandlerFunc, configured []Middleware) HandlerFunc {
+	server.mu.Lock()
+	middleware := append(slices.Clip(server.middleware), configured...)
+	server.mu.Unlock()
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/types"
+)
+
+// Mux holds a set of message handlers, one per request type. The Server has one for requests that are not routed to an Institution, and each Institution has its own. Handlers can be registered while the server is running.
+type Mux struct {
+	mu       sync.Mutex
+	handlers map[types.MsgType]HandlerFunc
+}
+
+// NewMux returns an empty Mux, for example to set as Config.Handlers.
+func NewMux() *Mux {
+	return &Mux{
+		handlers: make(map[types.MsgType]HandlerFunc),
+	}
+}
+
+func (mux *Mux) handler(msgType types.MsgType) HandlerFunc {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	return mux.handlers[msgType]
+}
+
+// Handle registers the handler for requests of msgType. Passing a nil handler removes any existing one.
+func (mux *Mux) Handle(msgType types.MsgType, handler HandlerFunc) {
+	mux.mu.Lock()
+	if handler == nil {
+		delete(mux.handlers, msgType)
+	} else {
+		mux.handlers[msgType] = handler
+	}
+	mux.mu.Unlock()
+}
+
+func (mux *Mux) HandleBlockPatron(handleFunc func(ctx context.Context, r *request.BlockPatron) (*response.PatronStatus, error)) {
+	mux.Handle(types.ReqBlockPatron, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleCheckin(handleFunc func(ctx context.Context, r *request.Checkin) (*response.Checkin, error)) {
+	mux.Handle(types.ReqCheckin, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleCheckout(handleFunc func(ctx context.Context, r *request.Checkout) (*response.Checkout, error)) {
+	mux.Handle(types.ReqCheckout, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleHold(handleFunc func(ctx context.Context, r *request.Hold) (*response.Hold, error)) {
+	mux.Handle(types.ReqHold, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleItemInfo(handleFunc func(ctx context.Context, r *request.ItemInfo) (*response.ItemInfo, error)) {
+	mux.Handle(types.ReqItemInfo, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleItemStatusUpdate(handleFunc func(ctx context.Context, r *request.ItemStatusUpdate) (*response.ItemStatusUpdate, error)) {
+	mux.Handle(types.ReqItemStatusUpdate, adapt(handleFunc))
+}
+
+func (mux *Mux) HandlePatronStatus(handleFunc func(ctx context.Context, r *request.PatronStatus) (*response.PatronStatus, error)) {
+	mux.Handle(types.ReqPatronStatus, adapt(handleFunc))
+}
+
+func (mux *Mux) HandlePatronEnable(handleFunc func(ctx context.Context, r *request.PatronEnable) (*response.PatronEnable, error)) {
+	mux.Handle(types.ReqPatronEnable, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleRenew(handleFunc func(ctx context.Context, r *request.Renew) (*response.Renew, error)) {
+	mux.Handle(types.ReqRenew, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleEndPatronSession(handleFunc func(ctx context.Context, r *request.EndPatronSession) (*response.EndSession, error)) {
+	mux.Handle(types.ReqEndPatronSession, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleFeePaid(handleFunc func(ctx context.Context, r *request.FeePaid) (*response.FeePaid, error)) {
+	mux.Handle(types.ReqFeePaid, adapt(handleFunc))
+}
+
+func (mux *Mux) HandlePatronInfo(handleFunc func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error)) {
+	mux.Handle(types.ReqPatronInfo, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleRenewAll(handleFunc func(ctx context.Context, r *request.RenewAll) (*response.RenewAll, error)) {
+	mux.Handle(types.ReqRenewAll, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleSCLogin(handleFunc func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error)) {
+	mux.Handle(types.ReqSCLogin, adapt(handleFunc))
+}
+
+// HandleACSResend overrides the built-in ACS Resend handling, which retransmits the last message sent on the connection. The handler returns the message to retransmit, which may be of any response type; LastResponseFromContext gives it the last response. Its sequence number is left as is.
+func (mux *Mux) HandleACSResend(handleFunc func(ctx context.Context, r *request.ACSResend) (response.Response, error)) {
+	mux.Handle(types.ReqACSResend, adapt(handleFunc))
+}
+
+func (mux *Mux) HandleSCStatus(handleFunc func(ctx context.Context, r *request.SCStatus) (*response.ACSStatus, error)) {
+	mux.Handle(types.ReqSCStatus, adapt(handleFunc))
+}
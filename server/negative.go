@@ -0,0 +1,153 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pescew/sip/fields"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/types"
+	"github.com/pescew/sip/utils"
+)
+
+// negativeResponse builds a response to a request of msgType that reports failure, for use when the request could not be handled. Identifying fields are copied from the raw line so that it works even if the request could not be parsed. It returns nil for message types that have no response.
+func (server *Server) negativeResponse(cfg *serverConfig, msgType types.MsgType, line string) response.Response {
+	codes := map[string]string{"AO": "", "AA": "", "AB": ""}
+	if len(line) > 2 {
+		codes = utils.ExtractFields(line[2:], server.delimiterCharacter, codes)
+	}
+
+	institutionID := codes["AO"]
+	if institutionID == "" {
+		institutionID = cfg.institutionID
+	}
+	patronID := requiredField(codes["AA"])
+	itemID := requiredField(codes["AB"])
+	screenMessage := cfg.errorScreenMessage
+	now := time.Now()
+
+	switch msgType {
+	case types.ReqBlockPatron, types.ReqPatronStatus:
+		return &response.PatronStatus{
+			PatronStatus:    fields.PatronStatus{},
+			TransactionDate: now,
+			InstitutionID:   institutionID,
+			PatronID:        patronID,
+			ScreenMessage:   screenMessage,
+		}
+	case types.ReqCheckin:
+		return &response.Checkin{
+			Ok:                false,
+			TransactionDate:   now,
+			InstitutionID:     institutionID,
+			ItemID:            itemID,
+			PermanentLocation: requiredField(""),
+			ScreenMessage:     screenMessage,
+		}
+	case types.ReqCheckout:
+		return &response.Checkout{
+			Ok:              false,
+			TransactionDate: now,
+			InstitutionID:   institutionID,
+			PatronID:        patronID,
+			ItemID:          itemID,
+			DueDate:         requiredField(""),
+			ScreenMessage:   screenMessage,
+		}
+	case types.ReqHold:
+		return &response.Hold{
+			Ok:              false,
+			TransactionDate: now,
+			InstitutionID:   institutionID,
+			PatronID:        patronID,
+			ItemID:          itemID,
+			ScreenMessage:   screenMessage,
+		}
+	case types.ReqItemInfo:
+		return &response.ItemInfo{
+			CirculationStatus: 1,
+			FeeType:           1,
+			TransactionDate:   now,
+			DueDate:           requiredField(""),
+			ItemID:            itemID,
+			ScreenMessage:     screenMessage,
+		}
+	case types.ReqItemStatusUpdate:
+		return &response.ItemStatusUpdate{
+			ItemPropertiesOk: false,
+			TransactionDate:  now,
+			ItemID:           itemID,
+			ScreenMessage:    screenMessage,
+		}
+	case types.ReqPatronEnable:
+		return &response.PatronEnable{
+			PatronStatus:    fields.PatronStatus{},
+			TransactionDate: now,
+			InstitutionID:   institutionID,
+			PatronID:        patronID,
+			ScreenMessage:   screenMessage,
+		}
+	case types.ReqRenew:
+		return &response.Renew{
+			Ok:              false,
+			TransactionDate: now,
+			InstitutionID:   institutionID,
+			PatronID:        patronID,
+			ItemID:          itemID,
+			DueDate:         requiredField(""),
+			ScreenMessage:   screenMessage,
+		}
+	case types.ReqEndPatronSession:
+		return &response.EndSession{
+			EndSession:      false,
+			TransactionDate: now,
+			InstitutionID:   institutionID,
+			PatronID:        patronID,
+			ScreenMessage:   screenMessage,
+		}
+	case types.ReqFeePaid:
+		return &response.FeePaid{
+			PaymentAccepted: false,
+			TransactionDate: now,
+			InstitutionID:   institutionID,
+			PatronID:        patronID,
+			ScreenMessage:   screenMessage,
+		}
+	case types.ReqPatronInfo:
+		return &response.PatronInfo{
+			PatronStatus:    fields.PatronStatus{},
+			TransactionDate: now,
+			InstitutionID:   institutionID,
+			PatronID:        patronID,
+			ScreenMessage:   screenMessage,
+		}
+	case types.ReqRenewAll:
+		return &response.RenewAll{
+			Ok:              false,
+			TransactionDate: now,
+			InstitutionID:   institutionID,
+			ScreenMessage:   screenMessage,
+		}
+	case types.ReqSCLogin:
+		return &response.SCLogin{
+			Ok: false,
+		}
+	case types.ReqSCStatus:
+		return &response.ACSStatus{
+			OnlineStatus:    false,
+			DateTimeSync:    now,
+			ProtocolVersion: "2.00",
+			InstitutionID:   institutionID,
+			ScreenMessage:   screenMessage,
+		}
+	}
+
+	return nil
+}
+
+// requiredField returns a single space in place of an empty value so that a required field still passes validation.
+func requiredField(value string) string {
+	if value == "" {
+		return " "
+	}
+	return value
+}
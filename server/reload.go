@@ -0,0 +1,151 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"slices"
+)
+
+// serverConfig is the part of the server configuration that Reload can change. It is replaced as a whole, so a value read from it is never a mix of two configurations.
+type serverConfig struct {
+	logger                *slog.Logger
+	libraryID             string
+	institutionID         string
+	terminalUsername      string
+	terminalPassword      string
+	connectionTimeout     int
+	writeTimeout          int
+	maxLifetime           int
+	errorDetection        bool
+	requireErrorDetection bool
+	requireLogin          bool
+	allowedNetworks       []netip.Prefix
+	authenticator         Authenticator
+	tlsConfig             *tls.Config
+	tlsClientCertLogin    bool
+	sendErrorResponses    bool
+	errorScreenMessage    string
+	limitAction           LimitAction
+	limits                limits
+	webSocketOrigins      []string
+	handlers              *Mux
+	middleware            []Middleware
+
+	settings Settings
+}
+
+// newServerConfig builds the reloadable configuration from cfg, which must already be valid.
+func newServerConfig(cfg Config) *serverConfig {
+	var tlsConfig *tls.Config
+	if cfg.TLSConfig != nil {
+		tlsConfig = cfg.TLSConfig.Clone()
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		level := slog.LevelInfo
+		if cfg.DebugMode {
+			level = slog.LevelDebug
+		}
+		logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	}
+
+	return &serverConfig{
+		logger:                logger,
+		libraryID:             cfg.LibraryID,
+		institutionID:         cfg.InstitutionID,
+		terminalUsername:      cfg.TerminalUsername,
+		terminalPassword:      cfg.TerminalPassword,
+		connectionTimeout:     cfg.ConnectionTimeout,
+		writeTimeout:          cfg.WriteTimeout,
+		maxLifetime:           cfg.MaxLifetime,
+		errorDetection:        cfg.ErrorDetection,
+		requireErrorDetection: cfg.RequireErrorDetection,
+		requireLogin:          cfg.RequireLogin,
+		allowedNetworks:       slices.Clone(cfg.AllowedNetworks),
+		authenticator:         cfg.Authenticator,
+		tlsConfig:             tlsConfig,
+		tlsClientCertLogin:    cfg.TLSClientCertLogin,
+		sendErrorResponses:    cfg.SendErrorResponses,
+		errorScreenMessage:    cfg.ErrorScreenMessage,
+		limitAction:           cfg.LimitAction,
+		limits:                newLimits(cfg.MaxConnections, cfg.MaxConnectionsPerIP, cfg.MessageRate, cfg.MessageBurst),
+		webSocketOrigins:      slices.Clone(cfg.WebSocketOrigins),
+		handlers:              cfg.Handlers,
+		middleware:            slices.Clone(cfg.Middleware),
+
+		settings: Settings{
+			host:                  listenHost(cfg.Host),
+			port:                  cfg.Port,
+			unixSocket:            cfg.UnixSocket,
+			telnet:                cfg.Telnet,
+			debugMode:             cfg.DebugMode,
+			libraryID:             cfg.LibraryID,
+			institutionID:         cfg.InstitutionID,
+			terminalUsername:      cfg.TerminalUsername,
+			terminalPassword:      cfg.TerminalPassword,
+			terminatorCharacter:   cfg.TerminatorCharacter,
+			delimiterCharacter:    cfg.DelimiterCharacter,
+			connectionTimeout:     cfg.ConnectionTimeout,
+			writeTimeout:          cfg.WriteTimeout,
+			maxLifetime:           cfg.MaxLifetime,
+			errorDetection:        cfg.ErrorDetection,
+			requireErrorDetection: cfg.RequireErrorDetection,
+			requireLogin:          cfg.RequireLogin,
+			sendErrorResponses:    cfg.SendErrorResponses,
+			errorScreenMessage:    cfg.ErrorScreenMessage,
+
+			maxConnections:      cfg.MaxConnections,
+			maxConnectionsPerIP: cfg.MaxConnectionsPerIP,
+			messageRate:         cfg.MessageRate,
+			messageBurst:        cfg.MessageBurst,
+			limitAction:         cfg.LimitAction,
+
+			statusTimeoutPeriod:  cfg.StatusTimeoutPeriod,
+			statusRetriesAllowed: cfg.StatusRetriesAllowed,
+			statusOfflineOK:      cfg.StatusOfflineOK,
+		},
+	}
+}
+
+// config returns the current reloadable configuration.
+func (server *Server) config() *serverConfig {
+	return server.current.Load()
+}
+
+// Reload replaces the server configuration with cfg without closing any connections. The new Settings, Authenticator, limits, TLS certificates, Handlers and Middleware are swapped in together in one step, and apply to messages and connections from then on; a message already being handled keeps the configuration it started with, and sessions that are already logged in stay logged in.
+//
+// Handlers registered on the server itself, middleware added with Use and institutions are not part of the configuration and are not changed by Reload. They can be changed at any time with Handle, Use, AddInstitution and RemoveInstitution, each of which takes effect on its own from the next message; to change handlers together with the rest of the configuration, set Config.Handlers and Config.Middleware instead.
+//
+// The listen address, terminator and delimiter characters, metrics and WebSocket addresses and whether TLS or the telnet transport is enabled cannot be changed without a restart; Reload returns an error and keeps the current configuration if cfg changes them.
+func (server *Server) Reload(cfg Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return err
+	}
+
+	current := server.config()
+
+	switch {
+	case cfg.listenAddress() != server.listenAddr || cfg.UnixSocket != server.unixSocket || cfg.SocketActivation != server.socketActivation:
+		return fmt.Errorf("cannot change the listen address without a restart")
+	case cfg.TerminatorCharacter != server.terminatorCharacter || cfg.DelimiterCharacter != server.delimiterCharacter:
+		return fmt.Errorf("cannot change the terminator or delimiter character without a restart")
+	case cfg.MetricsAddr != server.metricsAddr:
+		return fmt.Errorf("cannot change the metrics address without a restart")
+	case cfg.WebSocketAddr != server.webSocketAddr:
+		return fmt.Errorf("cannot change the WebSocket address without a restart")
+	case (cfg.TLSConfig == nil) != (current.tlsConfig == nil):
+		return fmt.Errorf("cannot enable or disable TLS without a restart")
+	case cfg.Telnet != server.telnet:
+		return fmt.Errorf("cannot enable or disable the telnet transport without a restart")
+	}
+
+	server.current.Store(newServerConfig(cfg))
+
+	server.config().logger.Info("configuration reloaded")
+	return nil
+}
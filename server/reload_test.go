@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+func TestReload(t *testing.T) {
+	oldHash, err := HashPassword("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newHash, err := HashPassword("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := NewAccountTable(Account{LoginUserID: "kiosk", PasswordHash: oldHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.RequireLogin = true
+	cfg.Authenticator = accounts
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		settings := SettingsFromContext(ctx)
+		return &response.PatronInfo{
+			TransactionDate: time.Now(),
+			InstitutionID:   settings.InstitutionID(),
+			PatronID:        r.PatronID,
+		}, nil
+	})
+
+	addr := serveTest(t, srv)
+
+	existing, err := client.Dial(addr, client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer existing.Close()
+
+	login, err := existing.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "old"})
+	if err != nil || !login.Ok {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	rotated, err := NewAccountTable(Account{LoginUserID: "kiosk", PasswordHash: newHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Authenticator = rotated
+	cfg.InstitutionID = "reloaded"
+	err = srv.Reload(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := existing.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.InstitutionID != "reloaded" {
+		t.Fatalf("existing session did not see the reloaded settings: %+v", info)
+	}
+
+	for password, ok := range map[string]bool{"old": false, "new": true} {
+		c, err := client.Dial(addr, client.DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		login, err := c.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: password})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if login.Ok != ok {
+			t.Fatalf("login with %s password: got Ok=%t", password, login.Ok)
+		}
+	}
+
+	cfg.Port++
+	err = srv.Reload(cfg)
+	if err == nil {
+		t.Fatalf("expected an error when changing the listen address")
+	}
+}
+
+func TestReloadInFlight(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LibraryID = "lib-a"
+	cfg.InstitutionID = "inst-a"
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		if r.PatronID == "slow" {
+			started <- struct{}{}
+			<-release
+		}
+
+		settings := SettingsFromContext(ctx)
+		return &response.PatronInfo{
+			TransactionDate: time.Now(),
+			InstitutionID:   settings.InstitutionID(),
+			PatronID:        r.PatronID,
+			ScreenMessage:   settings.LibraryID(),
+		}, nil
+	})
+
+	addr := serveTest(t, srv)
+
+	// A request in progress while error detection is turned off is still answered with error detection, as it was received.
+	slow, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	req := &request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "slow"}
+	_, err = slow.Write([]byte(req.Marshal('|', '\r', true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	reloaded := cfg
+	reloaded.ErrorDetection = false
+	err = srv.Reload(reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	line, err := bufio.NewReader(slow).ReadString('\r')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(line, "AY0AZ") {
+		t.Fatalf("response to the request in flight lost its error detection: %q", line)
+	}
+
+	// Requests handled while the configuration is reloaded over and over always see the Settings of one configuration.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			next := cfg
+			next.ErrorDetection = false
+			if i%2 == 1 {
+				next.LibraryID = "lib-b"
+				next.InstitutionID = "inst-b"
+			}
+			err := srv.Reload(next)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	clientCfg := client.DefaultConfig()
+	clientCfg.ErrorDetection = false
+	c, err := client.Dial(addr, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.InstitutionID[len(info.InstitutionID)-1] != info.ScreenMessage[len(info.ScreenMessage)-1] {
+			t.Fatalf("response mixes two configurations: %s and %s", info.InstitutionID, info.ScreenMessage)
+		}
+	}
+}
+
+func TestReloadHandlers(t *testing.T) {
+	configFor := func(label string) Config {
+		handlers := NewMux()
+		handlers.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+			settings := SettingsFromContext(ctx)
+			return &response.PatronInfo{
+				TransactionDate: time.Now(),
+				InstitutionID:   settings.InstitutionID(),
+				PatronID:        r.PatronID,
+				PatronName:      label,
+			}, nil
+		})
+
+		cfg := DefaultConfig()
+		cfg.ErrorDetection = false
+		cfg.InstitutionID = "inst-" + label
+		cfg.Handlers = handlers
+		cfg.Middleware = []Middleware{func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, req request.Request) (response.Response, error) {
+				resp, err := next(ctx, req)
+				if info, ok := resp.(*response.PatronInfo); ok {
+					info.ScreenMessage = label
+				}
+				return resp, err
+			}
+		}}
+		return cfg
+	}
+
+	srv, err := New(configFor("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Handlers registered on the server are not used while Config.Handlers is set.
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		return &response.PatronInfo{TransactionDate: time.Now(), InstitutionID: "server", PatronID: r.PatronID, PatronName: "server"}, nil
+	})
+
+	addr := serveTest(t, srv)
+
+	// Requests handled while the handlers and configuration are reloaded over and over always see the handler, middleware and Settings of one configuration.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			label := "a"
+			if i%2 == 1 {
+				label = "b"
+			}
+			err := srv.Reload(configFor(label))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	clientCfg := client.DefaultConfig()
+	clientCfg.ErrorDetection = false
+	c, err := client.Dial(addr, clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.InstitutionID != "inst-"+info.PatronName || info.ScreenMessage != info.PatronName {
+			t.Fatalf("response mixes two configurations: %s, %s and %s", info.InstitutionID, info.PatronName, info.ScreenMessage)
+		}
+	}
+}
@@ -1,168 +1,370 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/netip"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pescew/sip/request"
 	"github.com/pescew/sip/response"
 	"github.com/pescew/sip/utils"
 )
 
+var ErrServerClosed = fmt.Errorf("SIP server closed")
+
 type Server struct {
 	mu sync.Mutex
 
-	listenAddr          netip.AddrPort
-	debugMode           bool
-	libraryID           string
-	institutionID       string
-	terminalUsername    string
-	terminalPassword    string
+	inShutdown  atomic.Bool
+	listeners   map[net.Listener]struct{}
+	activeConns map[*conn]struct{}
+
+	listenAddr          string
+	unixSocket          string
+	socketActivation    bool
+	telnet              bool
 	terminatorCharacter rune
 	delimiterCharacter  rune
-	connectionTimeout   int
-	errorDetection      bool
-
-	settings Settings
-
-	handleBlockPatron      func(conn *net.TCPConn, r *request.BlockPatron, s Settings)
-	handleCheckin          func(conn *net.TCPConn, r *request.Checkin, s Settings)
-	handleCheckout         func(conn *net.TCPConn, r *request.Checkout, s Settings)
-	handleHold             func(conn *net.TCPConn, r *request.Hold, s Settings)
-	handleItemInfo         func(conn *net.TCPConn, r *request.ItemInfo, s Settings)
-	handleItemStatusUpdate func(conn *net.TCPConn, r *request.ItemStatusUpdate, s Settings)
-	handlePatronStatus     func(conn *net.TCPConn, r *request.PatronStatus, s Settings)
-	handlePatronEnable     func(conn *net.TCPConn, r *request.PatronEnable, s Settings)
-	handleRenew            func(conn *net.TCPConn, r *request.Renew, s Settings)
-	handleEndPatronSession func(conn *net.TCPConn, r *request.EndPatronSession, s Settings)
-	handleFeePaid          func(conn *net.TCPConn, r *request.FeePaid, s Settings)
-	handlePatronInfo       func(conn *net.TCPConn, r *request.PatronInfo, s Settings)
-	handleRenewAll         func(conn *net.TCPConn, r *request.RenewAll, s Settings)
-	handleSCLogin          func(conn *net.TCPConn, r *request.SCLogin, s Settings)
-	handleACSResend        func(conn *net.TCPConn, r *request.ACSResend, s Settings)
-	handleSCStatus         func(conn *net.TCPConn, r *request.SCStatus, s Settings)
+
+	// current holds the configuration that can be changed by Reload.
+	current atomic.Pointer[serverConfig]
+
+	limiter *limiter
+
+	metrics     *metrics
+	metricsAddr string
+	metricsOnce sync.Once
+
+	webSocketAddr string
+	webSocketOnce sync.Once
+
+	// The handlers for requests that are not routed to an Institution.
+	*Mux
+
+	institutions map[string]*Institution
+	middleware   []Middleware
 }
 
 func New(cfg Config) (*Server, error) {
-	if cfg.ConnectionTimeout < 1 {
-		return nil, fmt.Errorf("invalid connection timeout - must be greater than zero seconds.")
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
 	}
 
-	if cfg.TerminatorCharacter == cfg.DelimiterCharacter {
-		return nil, fmt.Errorf("cannot use the same character for both Terminator and Delimiter")
-	}
+	utils.ConfigureEscapeCharacters(cfg.DelimiterCharacter, cfg.TerminatorCharacter)
+	request.InitValidator(cfg.DelimiterCharacter, cfg.TerminatorCharacter)
+	response.InitValidator(cfg.DelimiterCharacter, cfg.TerminatorCharacter)
 
-	if cfg.Port < 1 || cfg.Port > 65535 {
-		return nil, fmt.Errorf("invalid port - must be between 1-65535")
-	}
+	server := &Server{
+		listeners:   make(map[net.Listener]struct{}),
+		activeConns: make(map[*conn]struct{}),
+
+		listenAddr:          cfg.listenAddress(),
+		unixSocket:          cfg.UnixSocket,
+		socketActivation:    cfg.SocketActivation,
+		telnet:              cfg.Telnet,
+		terminatorCharacter: cfg.TerminatorCharacter,
+		delimiterCharacter:  cfg.DelimiterCharacter,
+
+		limiter: newLimiter(),
+
+		metrics:     newMetrics(),
+		metricsAddr: cfg.MetricsAddr,
+
+		webSocketAddr: cfg.WebSocketAddr,
 
-	host := cfg.Host
-	if strings.ToLower(host) == "localhost" {
-		host = "127.0.0.1"
+		Mux:          NewMux(),
+		institutions: make(map[string]*Institution),
 	}
-	listenIP, err := netip.ParseAddr(host)
-	if err != nil {
-		return nil, err
+	server.current.Store(newServerConfig(cfg))
+
+	return server, nil
+}
+
+// ListenAndServe listens on the configured Host and Port, Unix socket or activated sockets and then calls Serve, or ServeTLS if TLS is configured. With several activated sockets it serves all of them and returns when the first one stops, closing the others. It always returns a non-nil error; after Shutdown or Close the error is ErrServerClosed.
+func (server *Server) ListenAndServe() error {
+	if server.shuttingDown() {
+		return ErrServerClosed
 	}
-	listenAddress, err := netip.ParseAddrPort(fmt.Sprintf("%s:%d", listenIP.String(), cfg.Port))
+
+	listeners, err := server.listen()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	terminatorString := string(cfg.TerminatorCharacter)
-	delimiterString := string(cfg.DelimiterCharacter)
-
-	if strings.Contains(cfg.InstitutionID, terminatorString) {
-		return nil, fmt.Errorf(fmt.Sprintf("cannot use Terminator Character in Institution ID: %s", terminatorString))
-	} else if strings.Contains(cfg.InstitutionID, delimiterString) {
-		return nil, fmt.Errorf(fmt.Sprintf("cannot use Delimiter Character in Institution ID: %s", delimiterString))
+	serve := server.Serve
+	if server.config().tlsConfig != nil {
+		serve = server.ServeTLS
 	}
 
-	if strings.Contains(cfg.LibraryID, terminatorString) {
-		return nil, fmt.Errorf(fmt.Sprintf("cannot use Terminator Character in Library ID: %s", terminatorString))
-	} else if strings.Contains(cfg.LibraryID, delimiterString) {
-		return nil, fmt.Errorf(fmt.Sprintf("cannot use Delimiter Character in Library ID: %s", delimiterString))
+	if len(listeners) == 1 {
+		return serve(listeners[0])
 	}
 
-	if strings.Contains(cfg.TerminalUsername, terminatorString) {
-		return nil, fmt.Errorf(fmt.Sprintf("cannot use Terminator Character in Terminal Username: %s", terminatorString))
-	} else if strings.Contains(cfg.TerminalUsername, delimiterString) {
-		return nil, fmt.Errorf(fmt.Sprintf("cannot use Delimiter Character in Terminal Username: %s", delimiterString))
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			errs <- serve(listener)
+		}()
 	}
 
-	if strings.Contains(cfg.TerminalPassword, terminatorString) {
-		return nil, fmt.Errorf(fmt.Sprintf("cannot use Terminator Character in Terminal Password: %s", terminatorString))
-	} else if strings.Contains(cfg.TerminalPassword, delimiterString) {
-		return nil, fmt.Errorf(fmt.Sprintf("cannot use Delimiter Character in Terminal Password: %s", delimiterString))
+	err = <-errs
+	for _, listener := range listeners {
+		listener.Close()
 	}
+	return err
+}
 
-	utils.ConfigureEscapeCharacters(cfg.DelimiterCharacter, cfg.TerminatorCharacter)
-	request.InitValidator(cfg.DelimiterCharacter, cfg.TerminatorCharacter)
-	response.InitValidator(cfg.DelimiterCharacter, cfg.TerminatorCharacter)
+// ServeTLS is like Serve but wraps every accepted connection in TLS using the configured TLSConfig. Each handshake uses the TLSConfig current at the time, so certificates can be rotated with Reload.
+func (server *Server) ServeTLS(listener net.Listener) error {
+	if server.config().tlsConfig == nil {
+		return fmt.Errorf("cannot serve TLS without a TLS config")
+	}
 
-	return &Server{
-		listenAddr: listenAddress,
+	return server.Serve(server.tlsListener(listener))
+}
 
-		debugMode:           cfg.DebugMode,
-		libraryID:           cfg.LibraryID,
-		institutionID:       cfg.InstitutionID,
-		terminalUsername:    cfg.TerminalUsername,
-		terminalPassword:    cfg.TerminalPassword,
-		terminatorCharacter: cfg.TerminatorCharacter,
-		delimiterCharacter:  cfg.DelimiterCharacter,
-		connectionTimeout:   cfg.ConnectionTimeout,
-		errorDetection:      cfg.ErrorDetection,
-
-		settings: Settings{
-			host:                host,
-			port:                cfg.Port,
-			debugMode:           cfg.DebugMode,
-			libraryID:           cfg.LibraryID,
-			institutionID:       cfg.InstitutionID,
-			terminalUsername:    cfg.TerminalUsername,
-			terminalPassword:    cfg.TerminalPassword,
-			terminatorCharacter: cfg.TerminatorCharacter,
-			delimiterCharacter:  cfg.DelimiterCharacter,
-			connectionTimeout:   cfg.ConnectionTimeout,
-			errorDetection:      cfg.ErrorDetection,
+// tlsListener wraps listener in TLS using the TLSConfig current at the time of each handshake.
+func (server *Server) tlsListener(listener net.Listener) net.Listener {
+	return tls.NewListener(listener, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return server.config().tlsConfig, nil
 		},
-
-		handleBlockPatron:      nil,
-		handleCheckin:          nil,
-		handleCheckout:         nil,
-		handleHold:             nil,
-		handleItemInfo:         nil,
-		handleItemStatusUpdate: nil,
-		handlePatronStatus:     nil,
-		handlePatronEnable:     nil,
-		handleRenew:            nil,
-		handleEndPatronSession: nil,
-		handleFeePaid:          nil,
-		handlePatronInfo:       nil,
-		handleRenewAll:         nil,
-		handleSCLogin:          nil,
-		handleACSResend:        nil,
-		handleSCStatus:         nil,
-	}, nil
+	})
 }
 
-func (server *Server) ListenAndServe() error {
-	listener, err := net.ListenTCP("tcp", net.TCPAddrFromAddrPort(server.listenAddr))
-	if err != nil {
-		return err
+// Serve accepts connections on listener and handles each one in its own goroutine. The listener is closed when Serve returns. It always returns a non-nil error; after Shutdown or Close the error is ErrServerClosed.
+func (server *Server) Serve(listener net.Listener) error {
+	l := &onceCloseListener{Listener: listener}
+	defer l.Close()
+
+	if !server.trackListener(l, true) {
+		return ErrServerClosed
 	}
+	defer server.trackListener(l, false)
 
+	server.serveMetrics()
+	server.serveWebSocket()
+
+	var retryDelay time.Duration
 	for {
-		conn, err := listener.AcceptTCP()
+		rwc, err := l.Accept()
 		if err != nil {
-			log.Printf("Error accepting TCP connection: %s\n", err.Error())
+			if server.shuttingDown() {
+				return ErrServerClosed
+			}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if retryDelay == 0 {
+					retryDelay = 5 * time.Millisecond
+				} else {
+					retryDelay = min(retryDelay*2, time.Second)
+				}
+				server.config().logger.Error("error accepting connection", "error", err, "retry_in", retryDelay)
+				time.Sleep(retryDelay)
+				continue
+			}
+			return err
+		}
+		retryDelay = 0
+
+		c := server.newConn(rwc)
+		if c == nil {
+			rwc.Close()
 			continue
 		}
+		if !server.trackConn(c, true) {
+			rwc.Close()
+			return ErrServerClosed
+		}
+
+		go server.handleConnection(c)
+	}
+}
+
+// newConn sets up the connection state for rwc, or returns nil if its source IP is outside the allowed networks.
+func (server *Server) newConn(rwc net.Conn) *conn {
+	cfg := server.config()
+
+	ip := remoteIP(rwc.RemoteAddr())
+	if len(cfg.allowedNetworks) > 0 && ip.IsValid() && !networksContain(cfg.allowedNetworks, ip) {
+		cfg.logger.Warn("refusing connection outside allowed networks", "remote_addr", rwc.RemoteAddr().String())
+		return nil
+	}
+
+	c := &conn{rwc: rwc, session: newSession(rwc.RemoteAddr().String()), ip: ip, telnet: server.telnet}
+	c.logger = cfg.logger.With("remote_addr", c.session.RemoteAddr())
+	if cfg.maxLifetime > 0 {
+		c.expires = time.Now().Add(time.Second * time.Duration(cfg.maxLifetime))
+	}
+	c.state.Store(int32(stateIdle))
+	return c
+}
+
+// Shutdown gracefully shuts down the server. It closes all listeners, then closes idle connections and waits for connections that are in the middle of a message to finish it. If ctx expires first, Shutdown returns the context's error and the remaining connections are left open; call Close to drop them.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.inShutdown.Store(true)
+
+	server.mu.Lock()
+	err := server.closeListenersLocked()
+	server.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if server.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and all connections, including those in the middle of a message. Use Shutdown for a graceful stop.
+func (server *Server) Close() error {
+	server.inShutdown.Store(true)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	err := server.closeListenersLocked()
+	for c := range server.activeConns {
+		c.rwc.Close()
+		delete(server.activeConns, c)
+	}
+	return err
+}
+
+const shutdownPollInterval = 100 * time.Millisecond
+
+func (server *Server) shuttingDown() bool {
+	return server.inShutdown.Load()
+}
+
+func (server *Server) trackListener(listener net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if add {
+		if server.shuttingDown() {
+			return false
+		}
+		server.listeners[listener] = struct{}{}
+	} else {
+		delete(server.listeners, listener)
+	}
+	return true
+}
+
+func (server *Server) trackConn(c *conn, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
 
-		go server.handleConnection(conn)
+	if add {
+		if server.shuttingDown() {
+			return false
+		}
+		server.activeConns[c] = struct{}{}
+	} else {
+		delete(server.activeConns, c)
 	}
+	return true
+}
+
+func (server *Server) closeListenersLocked() error {
+	var err error
+	for listener := range server.listeners {
+		closeErr := listener.Close()
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// closeIdleConns closes every connection that is waiting for its next message and reports whether no connections remain.
+func (server *Server) closeIdleConns() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	for c := range server.activeConns {
+		if c.state.CompareAndSwap(int32(stateIdle), int32(stateClosed)) {
+			c.rwc.Close()
+			delete(server.activeConns, c)
+		}
+	}
+	return len(server.activeConns) == 0
+}
+
+type connState int32
+
+const (
+	stateIdle connState = iota
+	stateActive
+	stateClosed
+)
+
+// conn is a connection accepted by Serve. Its state moves between idle (waiting for a message) and active (handling one) so that Shutdown can tell which connections are safe to close.
+type conn struct {
+	rwc     net.Conn
+	state   atomic.Int32
+	session *Session
+
+	logger *slog.Logger
+
+	// The source IP the connection limits and message rate are applied to, invalid for connections over a Unix socket, and whether the connection holds a slot under the connection limits.
+	ip       netip.Addr
+	admitted bool
+
+	// Whether the connection opens with a telnet login.
+	telnet bool
+
+	// The time the connection must be closed by, or the zero time if it has no maximum lifetime.
+	expires time.Time
+
+	// The last response written, kept for answering ACS Resend requests. Only used by the connection's own goroutine.
+	lastResponse       response.Response
+	lastResponseString string
+
+	// Whether the SC has sent a request with a checksum on the connection, after which every request must have one. Only used by the connection's own goroutine.
+	checksummed bool
+
+	// The account found by the Authenticator for the SC Login being handled.
+	loginAccount *Account
+
+	// The configuration the message being handled was received under, or nil between messages. Only used by the connection's own goroutine.
+	cfg *serverConfig
+}
+
+// connConfig returns the configuration c's current message is handled with, or the current configuration between messages.
+func (server *Server) connConfig(c *conn) *serverConfig {
+	if c.cfg != nil {
+		return c.cfg
+	}
+	return server.config()
+}
+
+// onceCloseListener guards against closing a listener twice from both Serve and Shutdown.
+type onceCloseListener struct {
+	net.Listener
+	once     sync.Once
+	closeErr error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() {
+		l.closeErr = l.Listener.Close()
+	})
+	return l.closeErr
 }
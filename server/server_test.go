@@ -0,0 +1,527 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/fields"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+func TestServeAndShutdown(t *testing.T) {
+	srv, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: r.LoginUserID == "kiosk" && r.LoginPassword == "secret"}, nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(listener)
+	}()
+
+	c, err := client.Dial(listener.Addr().String(), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	resp, err := c.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Ok {
+		t.Fatalf("login failed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = <-served
+	if !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve returned %v, expected ErrServerClosed", err)
+	}
+
+	_, err = c.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"})
+	if err == nil {
+		t.Fatalf("expected idle connection to be closed by Shutdown")
+	}
+
+	err = srv.ListenAndServe()
+	if !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("ListenAndServe returned %v, expected ErrServerClosed", err)
+	}
+}
+
+func serveTest(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go srv.Serve(listener)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	return listener.Addr().String()
+}
+
+func TestACSResend(t *testing.T) {
+	srv, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: true}, nil
+	})
+
+	c, err := client.Dial(serveTest(t, srv), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resent, err := c.Resend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login, ok := resent.(*response.SCLogin)
+	if !ok || !login.Ok {
+		t.Fatalf("unexpected resent response: %v", resent)
+	}
+
+	srv.HandleACSResend(func(ctx context.Context, r *request.ACSResend) (response.Response, error) {
+		last, ok := LastResponseFromContext(ctx).(*response.SCLogin)
+		if !ok {
+			t.Errorf("unexpected last response: %v", LastResponseFromContext(ctx))
+			return nil, nil
+		}
+		return &response.SCLogin{Ok: !last.Ok}, nil
+	})
+
+	resent, err = c.Resend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login, ok = resent.(*response.SCLogin)
+	if !ok || login.Ok {
+		t.Fatalf("ACS Resend handler was not used: %v", resent)
+	}
+}
+
+func TestSCResend(t *testing.T) {
+	srv, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: true}, nil
+	})
+
+	conn, err := net.Dial("tcp", serveTest(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	line := (&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret", SeqNum: 1}).Marshal('|', '\r', true)
+	corrupted := strings.Replace(line, "kiosk", "kiosc", 1)
+
+	// A request without AY and AZ is accepted until the SC has sent one with them, and then answered with an SC Resend, like one whose checksum was cut off.
+	unchecked := (&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"}).Marshal('|', '\r', false)
+	truncated := line[:len(line)-6] + "\r"
+	resend := (&response.SCResend{}).Marshal('|', '\r', true)
+
+	reader := bufio.NewReader(conn)
+	for _, tc := range []struct {
+		send   string
+		expect string
+	}{
+		{unchecked, (&response.SCLogin{Ok: true, SeqNum: 0}).Marshal('|', '\r', true)},
+		{corrupted, resend},
+		{line, (&response.SCLogin{Ok: true, SeqNum: 1}).Marshal('|', '\r', true)},
+		{unchecked, resend},
+		{truncated, resend},
+	} {
+		_, err = conn.Write([]byte(tc.send))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := reader.ReadString('\r')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tc.expect {
+			t.Fatalf("got %q, expected %q", got, tc.expect)
+		}
+	}
+}
+
+func TestRequireErrorDetection(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireErrorDetection = true
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: true}, nil
+	})
+
+	conn, err := net.Dial("tcp", serveTest(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	for _, tc := range []struct {
+		send   string
+		expect string
+	}{
+		{(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"}).Marshal('|', '\r', false), (&response.SCResend{}).Marshal('|', '\r', true)},
+		{(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret", SeqNum: 2}).Marshal('|', '\r', true), (&response.SCLogin{Ok: true, SeqNum: 2}).Marshal('|', '\r', true)},
+	} {
+		_, err = conn.Write([]byte(tc.send))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := reader.ReadString('\r')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tc.expect {
+			t.Fatalf("got %q, expected %q", got, tc.expect)
+		}
+	}
+}
+
+func TestRequireLogin(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireLogin = true
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: r.LoginPassword == "secret"}, nil
+	})
+
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		session := SessionFromContext(ctx)
+		return &response.PatronInfo{
+			TransactionDate: time.Now(),
+			InstitutionID:   r.InstitutionID,
+			PatronID:        r.PatronID,
+			PatronName:      session.LoginUserID() + "@" + session.LocationCode(),
+		}, nil
+	})
+
+	conn, err := net.Dial("tcp", serveTest(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	c, err := client.New(conn, client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patronInfo := (&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"}).Marshal('|', '\r', true)
+	_, err = conn.Write([]byte(patronInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login, err := c.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret", LocationCode: "branch"})
+	if err != nil {
+		t.Fatalf("Patron Info before SC Login was not refused: %v", err)
+	}
+	if !login.Ok {
+		t.Fatalf("login failed")
+	}
+
+	info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.PatronName != "kiosk@branch" {
+		t.Fatalf("unexpected session in handler: %s", info.PatronName)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	srv, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: false}, nil
+	})
+
+	var seen []string
+	srv.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req request.Request) (response.Response, error) {
+			resp, err := next(ctx, req)
+			seen = append(seen, MsgTypeFromContext(ctx).ID())
+			return resp, err
+		}
+	}, func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req request.Request) (response.Response, error) {
+			resp, err := next(ctx, req)
+			if login, ok := resp.(*response.SCLogin); ok && SessionFromContext(ctx) != nil {
+				login.Ok = true
+			}
+			return resp, err
+		}
+	})
+
+	c, err := client.Dial(serveTest(t, srv), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	login, err := c.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !login.Ok {
+		t.Fatalf("middleware did not rewrite the response")
+	}
+
+	_, err = c.Resend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(seen, ",") != "93,97" {
+		t.Fatalf("unexpected messages seen by middleware: %v", seen)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SendErrorResponses = true
+	cfg.ErrorScreenMessage = "Please see staff"
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleCheckout(func(ctx context.Context, r *request.Checkout) (*response.Checkout, error) {
+		var items map[string]string
+		items[r.ItemID] = r.PatronID
+		return nil, nil
+	})
+
+	c, err := client.Dial(serveTest(t, srv), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Checkout(&request.Checkout{
+			TransactionDate: time.Now(),
+			NBDueDate:       time.Now(),
+			InstitutionID:   "inst",
+			PatronID:        "johndoe",
+			ItemID:          "1234567890",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Ok || resp.ItemID != "1234567890" || resp.ScreenMessage != "Please see staff" {
+			t.Fatalf("unexpected error response: %v", resp)
+		}
+	}
+}
+
+func TestDefaultSCStatusHandler(t *testing.T) {
+	srv, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: true}, nil
+	})
+	srv.HandleCheckout(func(ctx context.Context, r *request.Checkout) (*response.Checkout, error) {
+		return nil, nil
+	})
+	srv.HandleSCStatus(srv.DefaultSCStatusHandler)
+
+	c, err := client.Dial(serveTest(t, srv), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	status, err := c.Status(&request.SCStatus{MaxPrintWidth: 40, ProtocolVersion: "2.00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fields.SupportedMessages{
+		Checkout:      true,
+		SCACSStatus:   true,
+		RequestResend: true,
+		Login:         true,
+	}
+
+	if status.SupportedMessages != expected || !status.CheckoutOK || status.CheckinOK {
+		t.Fatalf("unexpected ACS Status: %+v", status)
+	}
+
+	if status.InstitutionID != "inst" || status.RetriesAllowed != 3 {
+		t.Fatalf("unexpected ACS Status: %+v", status)
+	}
+}
+
+func TestConnectionTimeouts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ConnectionTimeout = 1
+	cfg.MaxLifetime = 2
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCStatus(srv.DefaultSCStatusHandler)
+
+	c, err := client.Dial(serveTest(t, srv), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	for time.Since(start) < 1500*time.Millisecond {
+		_, err = c.Status(&request.SCStatus{MaxPrintWidth: 40, ProtocolVersion: "2.00"})
+		if err != nil {
+			t.Fatalf("connection closed after %s despite heartbeats: %v", time.Since(start), err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	time.Sleep(time.Until(start.Add(2100 * time.Millisecond)))
+
+	_, err = c.Status(&request.SCStatus{MaxPrintWidth: 40, ProtocolVersion: "2.00"})
+	if err == nil {
+		t.Fatalf("connection was not closed after its maximum lifetime")
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be written by the server while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	var logs syncBuffer
+
+	cfg := DefaultConfig()
+	cfg.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: true}, nil
+	})
+
+	c, err := client.Dial(serveTest(t, srv), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.Login(&request.SCLogin{LoginUserID: "kiosk", LoginPassword: "terminal-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(logs.String(), "handled SIP request") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out := logs.String()
+	if strings.Contains(out, "terminal-secret") {
+		t.Fatalf("password logged: %s", out)
+	}
+	for _, expect := range []string{"msg_type=", "seq_num=", "remote_addr=", "latency=", "kiosk"} {
+		if !strings.Contains(out, expect) {
+			t.Fatalf("log output is missing %s: %s", expect, out)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"sync"
+)
+
+// Session holds the state of a single SC connection. A new Session is created for every accepted connection and is available to handlers through SessionFromContext.
+type Session struct {
+	mu sync.RWMutex
+
+	remoteAddr    string
+	tlsIdentity   string
+	loggedIn      bool
+	loginUserID   string
+	locationCode  string
+	account       *Account
+	maxPrintWidth int
+}
+
+func newSession(remoteAddr string) *Session {
+	return &Session{
+		remoteAddr: remoteAddr,
+	}
+}
+
+func (s *Session) RemoteAddr() string {
+	return s.remoteAddr
+}
+
+// TLSIdentity is the subject common name of the verified TLS client certificate, or empty if the connection did not present one.
+func (s *Session) TLSIdentity() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tlsIdentity
+}
+
+// LoggedIn reports whether the last SC Login on this connection was answered with Ok.
+func (s *Session) LoggedIn() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loggedIn
+}
+
+func (s *Session) LoginUserID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loginUserID
+}
+
+func (s *Session) LocationCode() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.locationCode
+}
+
+// Account returns the terminal account the session logged in with through the Authenticator, if any.
+func (s *Session) Account() (Account, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.account == nil {
+		return Account{}, false
+	}
+	return *s.account, true
+}
+
+// MaxPrintWidth is the print width sent by the SC in its last SC Status request, or zero if none was sent.
+func (s *Session) MaxPrintWidth() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxPrintWidth
+}
+
+// setLogin records the outcome of an SC Login. The location code of the account, if it has one, takes precedence over the one sent by the SC.
+func (s *Session) setLogin(ok bool, loginUserID, locationCode string, account *Account) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loggedIn = ok
+	if ok {
+		s.loginUserID = loginUserID
+		s.locationCode = locationCode
+		s.account = account
+		if account != nil && account.LocationCode != "" {
+			s.locationCode = account.LocationCode
+		}
+	} else {
+		s.loginUserID = ""
+		s.locationCode = ""
+		s.account = nil
+	}
+}
+
+func (s *Session) setMaxPrintWidth(maxPrintWidth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxPrintWidth = maxPrintWidth
+}
+
+func (s *Session) setTLSIdentity(identity string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tlsIdentity = identity
+}
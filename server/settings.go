@@ -1,47 +1,297 @@
 package server
 
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
 type Config struct {
-	Host                string
-	Port                int
-	DebugMode           bool
+	// Host is the IP address or hostname to listen on, with IPv6 addresses optionally in brackets. Empty or "::" listens on all interfaces.
+	Host string
+	Port int
+
+	// UnixSocket is the path of a Unix domain socket to listen on instead of Host and Port, for example behind a local stunnel or HAProxy front end. A stale socket file left by an earlier run is replaced.
+	UnixSocket string
+
+	// SocketActivation makes ListenAndServe serve the sockets passed in by systemd (LISTEN_FDS) instead of opening its own; see ActivationListeners.
+	SocketActivation bool
+
+	// Telnet expects each connection to open with a telnet-style "login:" and "password:" prompt before SIP traffic starts, as used by some older self-check units. Telnet IAC negotiation is stripped from the stream, telnet line endings are read as a carriage return, and the login is checked the same way as an SC Login request. It requires TerminatorCharacter to be '\r'.
+	Telnet bool
+
+	DebugMode bool
+
+	// Logger receives the server's structured log output. Passwords are masked in logged SIP messages. If nil, logs are written as text to standard error, at debug level when DebugMode is set.
+	Logger *slog.Logger
+
 	LibraryID           string
 	InstitutionID       string
 	TerminalUsername    string
 	TerminalPassword    string
 	TerminatorCharacter rune
 	DelimiterCharacter  rune
-	ConnectionTimeout   int
-	ErrorDetection      bool
+
+	// ErrorDetection checks the AY sequence number and AZ checksum of requests, answering an SC Resend when they do not match, and adds them to every response. Once an SC has sent a request with a checksum on a connection, every later request on it must have one too, so that a line whose checksum was cut off or garbled is not taken as one sent without it. Only the requests before the first checksum, such as an SC Login from an SC that starts error detection after logging in, are accepted without one.
+	ErrorDetection bool
+
+	// RequireErrorDetection makes ErrorDetection require a checksum on every request, including the first on a connection. ACS Resend requests are exempt.
+	RequireErrorDetection bool
+
+	// ConnectionTimeout is the number of seconds to wait for the next message before closing the connection. It is refreshed after every message, so an SC sending regular SC Status heartbeats stays connected.
+	ConnectionTimeout int
+
+	// WriteTimeout is the number of seconds allowed for writing a response.
+	WriteTimeout int
+
+	// MaxLifetime is the number of seconds after which a connection is closed regardless of activity. Zero means no limit.
+	MaxLifetime int
+
+	// AllowedNetworks restricts the listener to source IPs within these networks. Connections from elsewhere are closed as soon as they are accepted. Empty allows any source. Connections over a Unix socket have no source IP and are not checked.
+	AllowedNetworks []netip.Prefix
+
+	// RequireLogin refuses every message except SC Login and SC Status until an SC Login on the connection has succeeded.
+	RequireLogin bool
+
+	// Authenticator decides every SC Login request, including those sent through the telnet login prompt. Logins it refuses, or from outside the account's AllowedNetworks, are answered with Ok=false. Accepted logins are answered with Ok=true, or, if an SC Login handler is registered, passed on to it with the account available from AccountFromContext, so that it can refuse them for its own reasons.
+	Authenticator Authenticator
+
+	// TLSConfig enables TLS on the listener when set. Client certificates are verified according to its ClientAuth setting.
+	TLSConfig *tls.Config
+
+	// TLSClientCertLogin treats a verified client certificate as a successful SC Login, using the certificate's subject common name as the terminal identity. If Authenticator is set it must implement AccountLookup, and the common name must be the login user ID of an account that allows the source IP; otherwise the connection is not logged in.
+	TLSClientCertLogin bool
+
+	// SendErrorResponses answers requests that could not be parsed or handled, including handler panics, with a negative response (for example Ok=false) carrying ErrorScreenMessage.
+	SendErrorResponses bool
+	ErrorScreenMessage string
+
+	// MaxConnections and MaxConnectionsPerIP cap the number of concurrent connections in total and from a single source IP. Zero means no limit. Connections over a Unix socket only count towards MaxConnections.
+	MaxConnections      int
+	MaxConnectionsPerIP int
+
+	// MessageRate is the number of messages per second allowed from a single source IP, with bursts of up to MessageBurst messages. Zero means no limit. It does not apply to connections over a Unix socket.
+	MessageRate  float64
+	MessageBurst int
+
+	// LimitAction is what happens to connections and messages over the limits above.
+	LimitAction LimitAction
+
+	// MetricsAddr is the address of an HTTP listener serving metrics in the Prometheus text format, for example "127.0.0.1:9100". Empty disables it; see also Server.MetricsHandler.
+	MetricsAddr string
+
+	// WebSocketAddr is the address of an HTTP listener accepting SIP over WebSocket, for example "0.0.0.0:8080", using TLSConfig if set. Empty disables it; see also Server.WebSocketHandler.
+	WebSocketAddr string
+
+	// WebSocketOrigins are the Origin headers the WebSocket endpoint accepts, for example "https://kiosk.example.org". Empty accepts any origin.
+	WebSocketOrigins []string
+
+	// Handlers, if set, is used instead of the server's own handlers for requests that are not routed to an Institution, and Middleware runs inside any middleware added with Use. Being part of the configuration, they are replaced by Reload in the same step as everything else, so a new handler never sees old Settings or the other way round. Pass a new Mux to Reload rather than changing the one in use.
+	Handlers   *Mux
+	Middleware []Middleware
+
+	// ACS Status values sent by DefaultSCStatusHandler.
+	StatusTimeoutPeriod  int
+	StatusRetriesAllowed int
+	StatusOfflineOK      bool
 }
 
 func DefaultConfig() Config {
 	return Config{
-		Host:                "127.0.0.1",
-		Port:                9000,
-		DebugMode:           false,
-		LibraryID:           "lib",
-		InstitutionID:       "inst",
-		TerminalUsername:    "",
-		TerminalPassword:    "",
-		TerminatorCharacter: '\r',
-		DelimiterCharacter:  '|',
-		ConnectionTimeout:   5,
-		ErrorDetection:      true,
+		Host:                  "127.0.0.1",
+		Port:                  9000,
+		UnixSocket:            "",
+		SocketActivation:      false,
+		Telnet:                false,
+		DebugMode:             false,
+		Logger:                nil,
+		LibraryID:             "lib",
+		InstitutionID:         "inst",
+		TerminalUsername:      "",
+		TerminalPassword:      "",
+		TerminatorCharacter:   '\r',
+		DelimiterCharacter:    '|',
+		ErrorDetection:        true,
+		RequireErrorDetection: false,
+		ConnectionTimeout:     5,
+		WriteTimeout:          5,
+		MaxLifetime:           0,
+		RequireLogin:          false,
+		Authenticator:         nil,
+		TLSConfig:             nil,
+		TLSClientCertLogin:    false,
+		SendErrorResponses:    false,
+		ErrorScreenMessage:    "Unable to process request. Please see staff.",
+
+		MaxConnections:      0,
+		MaxConnectionsPerIP: 0,
+		MessageRate:         0,
+		MessageBurst:        10,
+		LimitAction:         LimitDrop,
+
+		MetricsAddr: "",
+
+		WebSocketAddr:    "",
+		WebSocketOrigins: nil,
+
+		Handlers:   nil,
+		Middleware: nil,
+
+		StatusTimeoutPeriod:  30,
+		StatusRetriesAllowed: 3,
+		StatusOfflineOK:      false,
+	}
+}
+
+// Validate checks cfg with the same rules New enforces.
+func (cfg Config) Validate() error {
+	if cfg.ConnectionTimeout < 1 {
+		return fmt.Errorf("invalid connection timeout - must be greater than zero seconds.")
+	}
+
+	if cfg.WriteTimeout < 1 {
+		return fmt.Errorf("invalid write timeout - must be greater than zero seconds.")
+	}
+
+	if cfg.MaxLifetime < 0 {
+		return fmt.Errorf("invalid max lifetime - must not be negative.")
+	}
+
+	err := validateNetworks(cfg.AllowedNetworks)
+	if err != nil {
+		return fmt.Errorf("invalid allowed networks: %v", err)
+	}
+
+	if cfg.MaxConnections < 0 || cfg.MaxConnectionsPerIP < 0 {
+		return fmt.Errorf("invalid connection limit - must not be negative.")
+	}
+
+	if cfg.MessageRate < 0 || cfg.MessageBurst < 0 {
+		return fmt.Errorf("invalid message rate - must not be negative.")
+	}
+
+	if cfg.LimitAction < LimitDrop || cfg.LimitAction > LimitReject {
+		return fmt.Errorf("invalid limit action: %s", cfg.LimitAction.String())
+	}
+
+	if cfg.TerminatorCharacter == cfg.DelimiterCharacter {
+		return fmt.Errorf("cannot use the same character for both Terminator and Delimiter")
+	}
+
+	if cfg.Telnet && cfg.TerminatorCharacter != '\r' {
+		return fmt.Errorf("invalid terminator for telnet - must be a carriage return")
+	}
+
+	if cfg.StatusTimeoutPeriod < 0 || cfg.StatusTimeoutPeriod > 999 {
+		return fmt.Errorf("invalid status timeout period - must be between 0-999")
+	}
+
+	if cfg.StatusRetriesAllowed < 0 || cfg.StatusRetriesAllowed > 999 {
+		return fmt.Errorf("invalid status retries allowed - must be between 0-999")
+	}
+
+	if cfg.TLSClientCertLogin && (cfg.TLSConfig == nil || cfg.TLSConfig.ClientAuth < tls.VerifyClientCertIfGiven) {
+		return fmt.Errorf("TLS client certificate login requires a TLS config that verifies client certificates")
 	}
+
+	if cfg.UnixSocket != "" && cfg.SocketActivation {
+		return fmt.Errorf("cannot use both a Unix socket and socket activation")
+	}
+
+	if cfg.UnixSocket == "" && !cfg.SocketActivation {
+		if cfg.Port < 1 || cfg.Port > 65535 {
+			return fmt.Errorf("invalid port - must be between 1-65535")
+		}
+
+		err = validateHost(listenHost(cfg.Host))
+		if err != nil {
+			return fmt.Errorf("invalid host: %v", err)
+		}
+	}
+
+	terminatorString := string(cfg.TerminatorCharacter)
+	delimiterString := string(cfg.DelimiterCharacter)
+
+	if strings.Contains(cfg.InstitutionID, terminatorString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Terminator Character in Institution ID: %s", terminatorString))
+	} else if strings.Contains(cfg.InstitutionID, delimiterString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Delimiter Character in Institution ID: %s", delimiterString))
+	}
+
+	if strings.Contains(cfg.LibraryID, terminatorString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Terminator Character in Library ID: %s", terminatorString))
+	} else if strings.Contains(cfg.LibraryID, delimiterString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Delimiter Character in Library ID: %s", delimiterString))
+	}
+
+	if strings.Contains(cfg.TerminalUsername, terminatorString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Terminator Character in Terminal Username: %s", terminatorString))
+	} else if strings.Contains(cfg.TerminalUsername, delimiterString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Delimiter Character in Terminal Username: %s", delimiterString))
+	}
+
+	if strings.Contains(cfg.TerminalPassword, terminatorString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Terminator Character in Terminal Password: %s", terminatorString))
+	} else if strings.Contains(cfg.TerminalPassword, delimiterString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Delimiter Character in Terminal Password: %s", delimiterString))
+	}
+
+	if strings.Contains(cfg.ErrorScreenMessage, terminatorString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Terminator Character in Error Screen Message: %s", terminatorString))
+	} else if strings.Contains(cfg.ErrorScreenMessage, delimiterString) {
+		return fmt.Errorf(fmt.Sprintf("cannot use Delimiter Character in Error Screen Message: %s", delimiterString))
+	}
+
+	return nil
+}
+
+// listenHost removes the brackets around an IPv6 address.
+func listenHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// listenAddress returns the TCP address to listen on.
+func (cfg Config) listenAddress() string {
+	return net.JoinHostPort(listenHost(cfg.Host), strconv.Itoa(cfg.Port))
 }
 
 type Settings struct {
-	host                string
-	port                int
-	debugMode           bool
-	libraryID           string
-	institutionID       string
-	terminalUsername    string
-	terminalPassword    string
-	terminatorCharacter rune
-	delimiterCharacter  rune
-	connectionTimeout   int
-	errorDetection      bool
+	host                  string
+	port                  int
+	unixSocket            string
+	telnet                bool
+	debugMode             bool
+	libraryID             string
+	institutionID         string
+	terminalUsername      string
+	terminalPassword      string
+	terminatorCharacter   rune
+	delimiterCharacter    rune
+	connectionTimeout     int
+	writeTimeout          int
+	maxLifetime           int
+	errorDetection        bool
+	requireErrorDetection bool
+	requireLogin          bool
+	sendErrorResponses    bool
+	errorScreenMessage    string
+
+	maxConnections      int
+	maxConnectionsPerIP int
+	messageRate         float64
+	messageBurst        int
+	limitAction         LimitAction
+
+	statusTimeoutPeriod  int
+	statusRetriesAllowed int
+	statusOfflineOK      bool
 }
 
 func (s *Settings) Host() string {
@@ -52,6 +302,14 @@ func (s *Settings) Port() int {
 	return s.port
 }
 
+func (s *Settings) UnixSocket() string {
+	return s.unixSocket
+}
+
+func (s *Settings) Telnet() bool {
+	return s.telnet
+}
+
 func (s *Settings) DebugMode() bool {
 	return s.debugMode
 }
@@ -84,6 +342,62 @@ func (s *Settings) ConnectionTimeout() int {
 	return s.connectionTimeout
 }
 
+func (s *Settings) WriteTimeout() int {
+	return s.writeTimeout
+}
+
+func (s *Settings) MaxLifetime() int {
+	return s.maxLifetime
+}
+
 func (s *Settings) ErrorDetection() bool {
 	return s.errorDetection
 }
+
+func (s *Settings) RequireErrorDetection() bool {
+	return s.requireErrorDetection
+}
+
+func (s *Settings) RequireLogin() bool {
+	return s.requireLogin
+}
+
+func (s *Settings) SendErrorResponses() bool {
+	return s.sendErrorResponses
+}
+
+func (s *Settings) ErrorScreenMessage() string {
+	return s.errorScreenMessage
+}
+
+func (s *Settings) MaxConnections() int {
+	return s.maxConnections
+}
+
+func (s *Settings) MaxConnectionsPerIP() int {
+	return s.maxConnectionsPerIP
+}
+
+func (s *Settings) MessageRate() float64 {
+	return s.messageRate
+}
+
+func (s *Settings) MessageBurst() int {
+	return s.messageBurst
+}
+
+func (s *Settings) LimitAction() LimitAction {
+	return s.limitAction
+}
+
+func (s *Settings) StatusTimeoutPeriod() int {
+	return s.statusTimeoutPeriod
+}
+
+func (s *Settings) StatusRetriesAllowed() int {
+	return s.statusRetriesAllowed
+}
+
+func (s *Settings) StatusOfflineOK() bool {
+	return s.statusOfflineOK
+}
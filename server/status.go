@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pescew/sip/fields"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/types"
+)
+
+// SupportedMessages reports which messages the server answers, computed from the registered handlers. Request Resend is always supported because ACS Resend is handled by the server itself.
+func (server *Server) SupportedMessages() fields.SupportedMessages {
+	return server.supportedMessages(nil)
+}
+
+// supportedMessages is SupportedMessages for requests routed to inst, which may be nil.
+func (server *Server) supportedMessages(inst *Institution) fields.SupportedMessages {
+	registered := func(msgType types.MsgType) bool {
+		return server.handler(msgType) != nil || (inst != nil && inst.handler(msgType) != nil)
+	}
+
+	return fields.SupportedMessages{
+		PatronStatusRequest: registered(types.ReqPatronStatus),
+		Checkout:            registered(types.ReqCheckout),
+		Checkin:             registered(types.ReqCheckin),
+		BlockPatron:         registered(types.ReqBlockPatron),
+		SCACSStatus:         registered(types.ReqSCStatus),
+		RequestResend:       true,
+		Login:               registered(types.ReqSCLogin) || server.config().authenticator != nil,
+		PatronInformation:   registered(types.ReqPatronInfo),
+		EndPatronSession:    registered(types.ReqEndPatronSession),
+		FeePaid:             registered(types.ReqFeePaid),
+		ItemInformation:     registered(types.ReqItemInfo),
+		ItemStatusUpdate:    registered(types.ReqItemStatusUpdate),
+		PatronEnable:        registered(types.ReqPatronEnable),
+		Hold:                registered(types.ReqHold),
+		Renew:               registered(types.ReqRenew),
+		RenewAll:            registered(types.ReqRenewAll),
+	}
+}
+
+// DefaultSCStatusHandler answers SC Status requests with an ACS Status built from the server configuration and SupportedMessages, or from the Institution the request was routed to. Register it with HandleSCStatus(srv.DefaultSCStatusHandler).
+func (server *Server) DefaultSCStatusHandler(ctx context.Context, r *request.SCStatus) (*response.ACSStatus, error) {
+	s := SettingsFromContext(ctx)
+	supported := server.supportedMessages(InstitutionFromContext(ctx))
+
+	terminalLocation := s.LibraryID()
+	if session := SessionFromContext(ctx); session != nil && session.LocationCode() != "" {
+		terminalLocation = session.LocationCode()
+	}
+
+	return &response.ACSStatus{
+		OnlineStatus:      true,
+		CheckinOK:         supported.Checkin,
+		CheckoutOK:        supported.Checkout,
+		RenewalPolicy:     supported.Renew,
+		StatusUpdateOK:    supported.ItemStatusUpdate,
+		OfflineOK:         s.StatusOfflineOK(),
+		TimeoutPeriod:     s.StatusTimeoutPeriod(),
+		RetriesAllowed:    s.StatusRetriesAllowed(),
+		DateTimeSync:      time.Now(),
+		ProtocolVersion:   "2.00",
+		InstitutionID:     s.InstitutionID(),
+		LibraryName:       s.LibraryID(),
+		SupportedMessages: supported,
+		TerminalLocation:  terminalLocation,
+	}, nil
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"runtime/debug"
+	"strings"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"github.com/pescew/sip/types"
+)
+
+// Telnet command bytes, see RFC 854.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+type telnetState int
+
+const (
+	telnetData telnetState = iota
+	telnetCR
+	telnetCommand
+	telnetOption
+	telnetSubnegotiation
+	telnetSubnegotiationIAC
+)
+
+// telnetReader strips telnet IAC command sequences from the stream read from r, keeping escaped 255 bytes, and turns the CR LF and CR NUL line endings of a telnet client into a plain CR.
+type telnetReader struct {
+	r     io.Reader
+	state telnetState
+}
+
+func (tr *telnetReader) Read(p []byte) (int, error) {
+	for {
+		n, err := tr.r.Read(p)
+		n = tr.filter(p[:n])
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// filter removes the telnet commands from buf in place and returns the length of the data left.
+func (tr *telnetReader) filter(buf []byte) int {
+	n := 0
+	for _, b := range buf {
+		switch tr.state {
+		case telnetCR:
+			tr.state = telnetData
+			if b == '\n' || b == 0 {
+				continue
+			}
+			fallthrough
+		case telnetData:
+			switch b {
+			case telnetIAC:
+				tr.state = telnetCommand
+				continue
+			case '\r':
+				tr.state = telnetCR
+			}
+			buf[n] = b
+			n++
+		case telnetCommand:
+			switch {
+			case b == telnetIAC:
+				tr.state = telnetData
+				buf[n] = b
+				n++
+			case b == telnetSB:
+				tr.state = telnetSubnegotiation
+			case b >= telnetWILL && b <= telnetDONT:
+				tr.state = telnetOption
+			default:
+				tr.state = telnetData
+			}
+		case telnetOption:
+			tr.state = telnetData
+		case telnetSubnegotiation:
+			if b == telnetIAC {
+				tr.state = telnetSubnegotiationIAC
+			}
+		case telnetSubnegotiationIAC:
+			if b == telnetSE {
+				tr.state = telnetData
+			} else {
+				tr.state = telnetSubnegotiation
+			}
+		}
+	}
+	return n
+}
+
+// telnetLogin prompts for a login and password on a telnet connection and checks them as an SC Login request, so that they are decided by the SC Login handler or Authenticator like any other login. It reports whether the login succeeded; on failure the SC is told and the connection should be closed. A panic while deciding the login is recovered and logged like one handling a message, and refuses the login.
+func (server *Server) telnetLogin(ctx context.Context, c *conn, scanner *bufio.Scanner) (loggedIn bool) {
+	loginUserID, ok := server.telnetPrompt(c, scanner, "login: ")
+	if !ok {
+		return false
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Error("recovered from panic handling telnet login", "login_user_id", loginUserID, "panic", r, "stack", string(debug.Stack()))
+			server.write(c, "\r\nLogin incorrect\r\n")
+			loggedIn = false
+		}
+	}()
+
+	loginPassword, ok := server.telnetPrompt(c, scanner, "password: ")
+	if !ok {
+		return false
+	}
+
+	req := &request.SCLogin{LoginUserID: loginUserID, LoginPassword: loginPassword}
+	resp, err := server.dispatch(ctx, c, types.ReqSCLogin, req)
+	if err != nil {
+		c.logger.Error("error handling telnet login", "login_user_id", loginUserID, "error", err)
+	}
+
+	if login, ok := resp.(*response.SCLogin); !ok || !login.Ok {
+		c.logger.Warn("telnet login refused", "login_user_id", loginUserID)
+		server.write(c, "\r\nLogin incorrect\r\n")
+		return false
+	}
+
+	c.logger.Debug("logged in by telnet", "login_user_id", loginUserID)
+	return true
+}
+
+// telnetPrompt writes prompt to c and returns the line the SC answers with.
+func (server *Server) telnetPrompt(c *conn, scanner *bufio.Scanner, prompt string) (string, bool) {
+	if !server.write(c, prompt) {
+		return "", false
+	}
+
+	c.rwc.SetReadDeadline(server.deadline(c, server.config().connectionTimeout))
+	if !scanner.Scan() {
+		c.logger.Debug("connection closed during telnet login", "error", scanner.Err())
+		return "", false
+	}
+	return strings.TrimSpace(scanner.Text()), true
+}
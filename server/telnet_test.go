@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+func TestTelnetReader(t *testing.T) {
+	input := []byte("\xff\xfd\x18\xff\xfb\x01log\xff\xfa\x18\x00VT100\xff\xf0in\r\n\xff\xff\r\x0099AY\xff\xf1\r")
+
+	// Read one byte at a time so that every sequence is split across reads.
+	tr := &telnetReader{r: &byteReader{data: input}}
+	out, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("login\r\xff\r99AY\r")
+	if !bytes.Equal(out, expected) {
+		t.Fatalf("got %q, expected %q", out, expected)
+	}
+}
+
+// byteReader returns its data one byte per Read.
+type byteReader struct {
+	data []byte
+}
+
+func (br *byteReader) Read(p []byte) (int, error) {
+	if len(br.data) == 0 {
+		return 0, io.EOF
+	}
+	p[0] = br.data[0]
+	br.data = br.data[1:]
+	return 1, nil
+}
+
+func TestTelnetTerminator(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Telnet = true
+	cfg.TerminatorCharacter = '\n'
+
+	_, err := New(cfg)
+	if err == nil {
+		t.Fatalf("expected an error using telnet with a terminator other than a carriage return")
+	}
+}
+
+func TestTelnetLogin(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := NewAccountTable(Account{LoginUserID: "kiosk", PasswordHash: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Telnet = true
+	cfg.RequireLogin = true
+	cfg.Authenticator = accounts
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		return &response.PatronInfo{
+			TransactionDate: time.Now(),
+			InstitutionID:   r.InstitutionID,
+			PatronID:        r.PatronID,
+			PatronName:      SessionFromContext(ctx).LoginUserID(),
+		}, nil
+	})
+
+	addr := serveTest(t, srv)
+
+	login := func(t *testing.T, password string) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		expectPrompt(t, conn, "login: ")
+		_, err = conn.Write([]byte("\xff\xfb\x18\xff\xfd\x03kiosk\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectPrompt(t, conn, "password: ")
+		_, err = conn.Write([]byte(password + "\r\x00"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	conn := login(t, "wrong")
+	expectPrompt(t, conn, "\r\nLogin incorrect\r\n")
+	_, err = conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("expected the connection to be closed after a failed login, got %v", err)
+	}
+	conn.Close()
+
+	c, err := client.New(login(t, "secret"), client.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PatronName != "kiosk" {
+		t.Fatalf("unexpected session in handler: %s", info.PatronName)
+	}
+}
+
+func TestTelnetLoginPanic(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Telnet = true
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		panic("bad login handler")
+	})
+
+	addr := serveTest(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	expectPrompt(t, conn, "login: ")
+	_, err = conn.Write([]byte("kiosk\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPrompt(t, conn, "password: ")
+	_, err = conn.Write([]byte("secret\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPrompt(t, conn, "\r\nLogin incorrect\r\n")
+
+	// The panic only refused the login; the server still accepts connections.
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	expectPrompt(t, conn, "login: ")
+}
+
+func expectPrompt(t *testing.T, conn net.Conn, prompt string) {
+	t.Helper()
+
+	buf := make([]byte, len(prompt))
+	_, err := io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != prompt {
+		t.Fatalf("got %q, expected %q", buf, prompt)
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/client"
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+)
+
+func issueCert(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+func TestTLSClientCertLogin(t *testing.T) {
+	caCert, ca := issueCert(t, "test ca", nil, nil, true)
+	serverCert, _ := issueCert(t, "acs", ca, caCert.PrivateKey.(*ecdsa.PrivateKey), false)
+	kioskCert, _ := issueCert(t, "kiosk-01", ca, caCert.PrivateKey.(*ecdsa.PrivateKey), false)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	cfg := DefaultConfig()
+	cfg.RequireLogin = true
+	cfg.TLSClientCertLogin = true
+	cfg.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		session := SessionFromContext(ctx)
+		return &response.PatronInfo{
+			TransactionDate: time.Now(),
+			InstitutionID:   r.InstitutionID,
+			PatronID:        r.PatronID,
+			PatronName:      session.TLSIdentity() + "/" + session.LoginUserID(),
+		}, nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeTLS(listener)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	clientCfg := client.DefaultConfig()
+	clientCfg.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{kioskCert},
+		RootCAs:      pool,
+	}
+
+	c, err := client.Dial(listener.Addr().String(), clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.PatronName != "kiosk-01/kiosk-01" {
+		t.Fatalf("unexpected terminal identity: %s", info.PatronName)
+	}
+}
+
+func TestTLSClientCertLoginAccounts(t *testing.T) {
+	caCert, ca := issueCert(t, "test ca", nil, nil, true)
+	serverCert, _ := issueCert(t, "acs", ca, caCert.PrivateKey.(*ecdsa.PrivateKey), false)
+	kioskCert, _ := issueCert(t, "kiosk-01", ca, caCert.PrivateKey.(*ecdsa.PrivateKey), false)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	tests := []struct {
+		name     string
+		account  Account
+		loggedIn bool
+	}{
+		{"known account", Account{LoginUserID: "KIOSK-01", InstitutionID: "main"}, true},
+		{"allowed network", Account{LoginUserID: "kiosk-01", AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}, true},
+		{"other network", Account{LoginUserID: "kiosk-01", AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}, false},
+		{"unknown account", Account{LoginUserID: "kiosk-02"}, false},
+	}
+
+	hash, err := HashPassword("kiosk-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.account.PasswordHash = hash
+			accounts, err := NewAccountTable(tt.account)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := DefaultConfig()
+			cfg.RequireLogin = true
+			cfg.SendErrorResponses = true
+			cfg.ErrorScreenMessage = "Please see staff"
+			cfg.Authenticator = accounts
+			cfg.TLSClientCertLogin = true
+			cfg.TLSConfig = &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}
+
+			srv, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+				return &response.PatronInfo{
+					TransactionDate: time.Now(),
+					InstitutionID:   r.InstitutionID,
+					PatronID:        r.PatronID,
+					PatronName:      SessionFromContext(ctx).LoginUserID(),
+				}, nil
+			})
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.ServeTLS(listener)
+			t.Cleanup(func() {
+				srv.Close()
+			})
+
+			clientCfg := client.DefaultConfig()
+			clientCfg.TLSConfig = &tls.Config{
+				Certificates: []tls.Certificate{kioskCert},
+				RootCAs:      pool,
+			}
+
+			c, err := client.Dial(listener.Addr().String(), clientCfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			info, err := c.PatronInfo(&request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.loggedIn && info.PatronName != "kiosk-01" {
+				t.Fatalf("expected login as kiosk-01, got: %v", info)
+			}
+			if !tt.loggedIn && info.ScreenMessage != "Please see staff" {
+				t.Fatalf("expected request to be refused, got: %v", info)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketHandler returns an http.Handler that accepts SIP over WebSocket, for SCs such as browser-based kiosks that cannot open a TCP connection. Each text frame carries one SIP message, with or without the terminator character, and each response is sent as one text frame without it. The connection is otherwise handled like one accepted by Serve, with the same handlers, sessions, login requirements and limits. It is served on WebSocketAddr when that is configured, and can also be mounted on an existing HTTP server.
+func (server *Server) WebSocketHandler() http.Handler {
+	return websocket.Server{
+		Handshake: server.checkWebSocketOrigin,
+		Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = bufio.MaxScanTokenSize
+
+			rwc := &webSocketConn{
+				Conn:       ws,
+				remoteAddr: webSocketRemoteAddr(ws),
+				terminator: string(server.terminatorCharacter),
+			}
+
+			c := server.newConn(rwc)
+			if c == nil {
+				return
+			}
+			c.telnet = false
+			if !server.trackConn(c, true) {
+				return
+			}
+
+			server.handleConnection(c)
+		},
+	}
+}
+
+// checkWebSocketOrigin refuses WebSocket handshakes from origins not listed in WebSocketOrigins.
+func (server *Server) checkWebSocketOrigin(config *websocket.Config, req *http.Request) error {
+	origins := server.config().webSocketOrigins
+	if len(origins) == 0 {
+		return nil
+	}
+
+	origin := req.Header.Get("Origin")
+	if !slices.Contains(origins, origin) {
+		server.config().logger.Warn("refusing WebSocket connection from origin", "origin", origin, "remote_addr", req.RemoteAddr)
+		return fmt.Errorf("origin not allowed: %s", origin)
+	}
+	return nil
+}
+
+// webSocketRemoteAddr returns the TCP address of the client that opened ws, so that the allowed networks and per IP limits apply to it.
+func webSocketRemoteAddr(ws *websocket.Conn) net.Addr {
+	addrPort, err := netip.ParseAddrPort(ws.Request().RemoteAddr)
+	if err != nil {
+		return ws.RemoteAddr()
+	}
+	return net.TCPAddrFromAddrPort(addrPort)
+}
+
+// webSocketConn is a WebSocket connection that reads and writes SIP messages as a stream of terminated lines, one per frame.
+type webSocketConn struct {
+	*websocket.Conn
+	remoteAddr net.Addr
+	terminator string
+
+	// The rest of the last frame received that has not been read yet.
+	buf []byte
+}
+
+func (wc *webSocketConn) Read(p []byte) (int, error) {
+	if len(wc.buf) == 0 {
+		var msg string
+		err := websocket.Message.Receive(wc.Conn, &msg)
+		if err != nil {
+			return 0, err
+		}
+		if !strings.HasSuffix(msg, wc.terminator) {
+			msg += wc.terminator
+		}
+		wc.buf = []byte(msg)
+	}
+
+	n := copy(p, wc.buf)
+	wc.buf = wc.buf[n:]
+	return n, nil
+}
+
+func (wc *webSocketConn) Write(p []byte) (int, error) {
+	err := websocket.Message.Send(wc.Conn, strings.TrimSuffix(string(p), wc.terminator))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (wc *webSocketConn) RemoteAddr() net.Addr {
+	return wc.remoteAddr
+}
+
+func (server *Server) serveWebSocket() {
+	if server.webSocketAddr == "" {
+		return
+	}
+
+	server.webSocketOnce.Do(func() {
+		listener, err := net.Listen("tcp", server.webSocketAddr)
+		if err != nil {
+			server.config().logger.Error("error starting WebSocket listener", "addr", server.webSocketAddr, "error", err)
+			return
+		}
+
+		if server.config().tlsConfig != nil {
+			listener = server.tlsListener(listener)
+		}
+
+		if !server.trackListener(listener, true) {
+			listener.Close()
+			return
+		}
+
+		server.config().logger.Info("serving WebSocket", "addr", listener.Addr().String())
+		go func() {
+			defer server.trackListener(listener, false)
+			http.Serve(listener, server.WebSocketHandler())
+		}()
+	})
+}
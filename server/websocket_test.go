@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pescew/sip/request"
+	"github.com/pescew/sip/response"
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocket(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireLogin = true
+	cfg.SendErrorResponses = true
+	cfg.WebSocketOrigins = []string{"https://kiosk.example.org"}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.HandleSCLogin(func(ctx context.Context, r *request.SCLogin) (*response.SCLogin, error) {
+		return &response.SCLogin{Ok: r.LoginPassword == "secret"}, nil
+	})
+
+	srv.HandlePatronInfo(func(ctx context.Context, r *request.PatronInfo) (*response.PatronInfo, error) {
+		session := SessionFromContext(ctx)
+		return &response.PatronInfo{
+			TransactionDate: time.Now(),
+			InstitutionID:   r.InstitutionID,
+			PatronID:        r.PatronID,
+			PatronName:      session.LoginUserID(),
+		}, nil
+	})
+
+	httpServer := httptest.NewServer(srv.WebSocketHandler())
+	defer httpServer.Close()
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	_, err = websocket.Dial(url, "", "https://evil.example.org")
+	if err == nil {
+		t.Fatalf("expected a handshake from an unlisted origin to be refused")
+	}
+
+	ws, err := websocket.Dial(url, "", "https://kiosk.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	roundTrip := func(req request.Request) response.Response {
+		t.Helper()
+
+		// Frames are accepted with or without the terminator.
+		msg := strings.TrimSuffix(req.Marshal('|', '\r', true), "\r")
+		err := websocket.Message.Send(ws, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var line string
+		err = websocket.Message.Receive(ws, &line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(line, "\r") {
+			t.Fatalf("response frame includes the terminator: %q", line)
+		}
+
+		resp, _, _, err := response.UnmarshalVerified(line, '|', '\r')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	patronInfo := &request.PatronInfo{TransactionDate: time.Now(), InstitutionID: "inst", PatronID: "johndoe"}
+	if info, ok := roundTrip(patronInfo).(*response.PatronInfo); !ok || info.PatronName != "" {
+		t.Fatalf("Patron Info before SC Login was not refused: %#v", info)
+	}
+
+	login, ok := roundTrip(&request.SCLogin{LoginUserID: "web-kiosk", LoginPassword: "secret"}).(*response.SCLogin)
+	if !ok || !login.Ok {
+		t.Fatalf("login failed")
+	}
+
+	info, ok := roundTrip(patronInfo).(*response.PatronInfo)
+	if !ok || info.PatronName != "web-kiosk" {
+		t.Fatalf("unexpected session in handler: %#v", info)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "sip_active_sessions 1\n") {
+		t.Fatalf("WebSocket session not counted in metrics:\n%s", rec.Body.String())
+	}
+}
@@ -217,3 +217,13 @@ func (m MsgType) ID() string {
 func (m MsgType) String() string {
 	return msgTypes[m]
 }
+
+// FromID returns the MsgType for a two character SIP message identifier.
+func FromID(id string) (MsgType, bool) {
+	for i, msgID := range msgIDs {
+		if msgID == id && msgTypes[i] != "" {
+			return MsgType(i), true
+		}
+	}
+	return 0, false
+}
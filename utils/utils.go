@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"unicode/utf8"
 
 	"github.com/go-playground/validator/v10"
@@ -16,18 +17,44 @@ const (
 	// SIPMaxItemsPerRequest  = 100
 )
 
-var REPLACER *strings.Replacer
+var (
+	ErrChecksumMismatch = fmt.Errorf("SIP checksum mismatch")
+	ErrInvalidSeqNum    = fmt.Errorf("Invalid SIP sequence number")
+
+	// SensitiveFields maps the codes of fields masked by RedactFields to the names of the request struct fields that hold them, which are masked when requests are logged: CO login password, AD patron password and AC terminal password.
+	SensitiveFields = map[string]string{
+		"CO": "LoginPassword",
+		"AD": "PatronPassword",
+		"AC": "TerminalPassword",
+	}
+)
+
+// RedactedValue replaces the value of a sensitive field in logs.
+const RedactedValue = "****"
+
+// escapeReplacer removes the characters in chars from text escaped by EscapeSIP.
+type escapeReplacer struct {
+	replacer *strings.Replacer
+	chars    string
+}
+
+var currentReplacer atomic.Pointer[escapeReplacer]
 
 func EscapeSIP(text string) string {
-	return REPLACER.Replace(text)
+	return currentReplacer.Load().replacer.Replace(text)
 }
 
+// ConfigureEscapeCharacters sets the characters EscapeSIP removes. It does nothing if they are already the ones in use, and otherwise replaces them atomically, so it is safe to call while other goroutines are escaping text.
 func ConfigureEscapeCharacters(chars ...rune) {
+	if current := currentReplacer.Load(); current != nil && current.chars == string(chars) {
+		return
+	}
+
 	replace := []string{}
 	for _, char := range chars {
 		replace = slices.Concat(replace, []string{string(char), ""})
 	}
-	REPLACER = strings.NewReplacer(replace...)
+	currentReplacer.Store(&escapeReplacer{replacer: strings.NewReplacer(replace...), chars: string(chars)})
 }
 
 func YorN(field bool) string {
@@ -74,6 +101,65 @@ func AppendChecksum(msg string) string {
 	return msg + ComputeChecksum(msg)
 }
 
+// ErrorDetection describes the AY sequence number and AZ checksum fields found at the end of a message.
+type ErrorDetection struct {
+	ChecksumPresent bool
+	ChecksumValid   bool
+	SeqNumPresent   bool
+	SeqNumValid     bool
+	SeqNum          int
+}
+
+// Err returns ErrChecksumMismatch or ErrInvalidSeqNum if a field that is present is not valid.
+func (ed ErrorDetection) Err() error {
+	if ed.ChecksumPresent && !ed.ChecksumValid {
+		return ErrChecksumMismatch
+	}
+	if ed.SeqNumPresent && !ed.SeqNumValid {
+		return ErrInvalidSeqNum
+	}
+	return nil
+}
+
+// CheckErrorDetection verifies the error detection fields of line, which must not include the terminator. The checksum is only recognized as the final field ("AZ" followed by 4 hex digits) and the sequence number only directly before it ("AY" followed by 1 digit).
+func CheckErrorDetection(line string) ErrorDetection {
+	var ed ErrorDetection
+
+	runes := []rune(line)
+	if len(runes) < 6 || string(runes[len(runes)-6:len(runes)-4]) != "AZ" {
+		return ed
+	}
+
+	ed.ChecksumPresent = true
+	ed.ChecksumValid = strings.EqualFold(ComputeChecksum(string(runes[:len(runes)-4])), string(runes[len(runes)-4:]))
+
+	if len(runes) >= 9 && string(runes[len(runes)-9:len(runes)-7]) == "AY" {
+		ed.SeqNumPresent = true
+		seqNum := runes[len(runes)-7]
+		if seqNum >= '0' && seqNum <= '9' {
+			ed.SeqNumValid = true
+			ed.SeqNum = int(seqNum - '0')
+		}
+	}
+
+	return ed
+}
+
+// RedactFields returns line with the values of SensitiveFields replaced by RedactedValue, for logging. Fields are recognized at the start of each segment after a delimiter, so the fixed length part of a message is left unchanged.
+func RedactFields(line string, delimiter rune) string {
+	segments := strings.Split(line, string(delimiter))
+	for i := 1; i < len(segments); i++ {
+		seg := segments[i]
+		if len(seg) <= 2 {
+			continue
+		}
+		if _, ok := SensitiveFields[seg[0:2]]; ok {
+			segments[i] = seg[0:2] + RedactedValue
+		}
+	}
+	return strings.Join(segments, string(delimiter))
+}
+
 func GenerateLineScanner(terminator rune) func([]byte, bool) (int, []byte, error) {
 	terminatorBytes := []byte(string(terminator))
 	terminatorLength := len(terminatorBytes)